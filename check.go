@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// Explore all schedules of a program. The exit status is 1 if a schedule with
+// a different output is found and 2 if the search is incomplete.
+func checkCmd(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	addIncludeFlag(flags)
+	maxStates := flags.Int("max_states", 1000000,
+		"Stop after exploring this number of distinct states.")
+	maxCycles := flags.Uint64("max_cycles", 10000000,
+		"Do not explore schedules beyond this number of cycles.")
+	stdinStr := flags.String("stdin", "",
+		"Override standard input.")
+	stdinAddStr := flags.String("stdin_add", "",
+		"Append to standard input.")
+	diagnostics := flags.String("diagnostics", "text",
+		"Format of compile errors (text or json).")
+	flags.Parse(args)
+
+	program, err := options.Compile(flags.Args()...)
+	if err != nil {
+		exitDiagnostics(err, *diagnostics)
+	}
+	printWarnings(program.Warnings, *diagnostics)
+	bytes, err := ioutil.ReadAll(stdinReader(os.Stdin, *stdinStr, *stdinAddStr))
+	if err != nil {
+		exit(err)
+	}
+
+	result, err := program.Check(string(bytes), *maxStates, *maxCycles)
+	if err != nil {
+		exit(err)
+	}
+	if result.Schedule != nil {
+		schedule := pi.FormatSchedule(result.Schedule)
+		fmt.Printf("found a schedule with a different output after %v states\n%v", result.States,
+			diff(result.Output, result.Counter))
+		fmt.Printf("replay with: pi run -schedule=%q %v\n", schedule, strings.Join(flags.Args(), " "))
+		return 1
+	}
+	if !result.Complete {
+		fmt.Printf("no schedule with a different output in %v states (incomplete)\n", result.States)
+		return 2
+	}
+	fmt.Printf("ok: the output is the same for all schedules (%v states)\n", result.States)
+	return 0
+}
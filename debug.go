@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+const debugHelp = `step, s         Run the next node in the queue.
+deliver, d      Deliver messages (one cycle).
+cycle, c        Run all nodes in the queue and deliver messages.
+run, r          Run until a breakpoint, watchpoint or the end of the program.
+break file:line Break before running a node at this location.
+watch name      Break when a message is sent or delivered on this channel.
+clear           Remove all breakpoints and watchpoints.
+queue           List the nodes in the process queue.
+ether           List the messages in the ether.
+refs n          List the references of the n-th node in the queue.
+graph file      Write the state as a DOT graph to a file.
+help            Show this help.
+quit            Stop debugging.`
+
+// Debugger state for stepping through a program.
+type debugger struct {
+	Pi      pi.Pi
+	Scopes  map[*pi.Proc][]string
+	Labels  map[*pi.Channel]string // Names under which channels were seen
+	Breaks  map[string]bool        // Breakpoints (file:line)
+	Watches map[string]bool        // Watched channel names
+	Watched map[*pi.Channel]bool   // Channels that matched a watched name
+
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// Run an interactive debugger on the given program. Commands are read from in
+// and the program uses stdin and stdout.
+func debug(program *pi.Program, in io.Reader, stdin io.Reader, stdout io.Writer) {
+	dbg := debugger{
+		Scopes:  program.Scopes(),
+		Labels:  make(map[*pi.Channel]string),
+		Breaks:  make(map[string]bool),
+		Watches: make(map[string]bool),
+		Watched: make(map[*pi.Channel]bool),
+		stdin:   stdin,
+		stdout:  stdout,
+	}
+	dbg.Pi.Trace = program.Trace
+	dbg.Pi.Initialize(program.Proc)
+	dbg.printNext()
+
+	scanner := bufio.NewScanner(in)
+	for fmt.Fprint(stdout, "(debug) "); scanner.Scan(); fmt.Fprint(stdout, "(debug) ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+		switch fields[0] {
+		case "step", "s":
+			dbg.Step()
+			dbg.printNext()
+		case "deliver", "d":
+			dbg.Deliver()
+			dbg.printNext()
+		case "cycle", "c":
+			if dbg.RunQueue() {
+				dbg.Deliver()
+				dbg.printNext()
+			}
+		case "run", "r":
+			for dbg.RunQueue() && len(dbg.Pi.Ether) > 0 && dbg.Deliver() {
+			}
+			dbg.printNext()
+		case "break", "b":
+			dbg.Breaks[arg] = true
+		case "watch", "w":
+			dbg.Watches[arg] = true
+		case "clear":
+			dbg.Breaks = make(map[string]bool)
+			dbg.Watches = make(map[string]bool)
+			dbg.Watched = make(map[*pi.Channel]bool)
+		case "queue", "q":
+			for i, node := range dbg.Pi.Queue {
+				fmt.Fprintf(stdout, "%v: %v %v\n", i, node.Proc.Location,
+					node.Proc.CommandName(dbg.Scopes[node.Proc]))
+			}
+		case "ether", "e":
+			for _, m := range dbg.Pi.Ether {
+				fmt.Fprintf(stdout, "%v->%v\n", dbg.label(m.Content), dbg.label(m.Channel))
+			}
+		case "refs":
+			dbg.printRefs(arg)
+		case "graph":
+			dbg.writeGraph(arg)
+		case "help", "h":
+			fmt.Fprintln(stdout, debugHelp)
+		case "quit":
+			return
+		default:
+			fmt.Fprintf(stdout, "unknown command %v\n", fields[0])
+		}
+	}
+}
+
+// Step runs the next node and reports if no watchpoint was hit.
+func (dbg *debugger) Step() bool {
+	if len(dbg.Pi.Queue) == 0 {
+		fmt.Fprintln(dbg.stdout, "queue is empty")
+		return false
+	}
+	node := dbg.Pi.Queue[0]
+	names := dbg.Scopes[node.Proc]
+	for i, c := range node.Refs {
+		if i >= len(names) {
+			break
+		}
+		if _, ok := dbg.Labels[c]; !ok {
+			dbg.Labels[c] = names[i]
+		}
+		if dbg.Watches[names[i]] {
+			dbg.Watched[c] = true
+		}
+	}
+	dbg.Pi.RunNextNode()
+
+	if node.Proc.Command == pi.PISend {
+		channel := node.Refs[node.Proc.Channel]
+		if dbg.Watched[channel] {
+			fmt.Fprintf(dbg.stdout, "watch: %v sends %v->%v\n", node.Proc.Location,
+				dbg.label(node.Refs[node.Proc.Message]), dbg.label(channel))
+			return false
+		}
+	}
+	return true
+}
+
+// RunQueue runs nodes until the queue is empty and reports if no breakpoint or
+// watchpoint was hit.
+func (dbg *debugger) RunQueue() bool {
+	for len(dbg.Pi.Queue) > 0 {
+		if !dbg.Step() {
+			return false
+		}
+		if len(dbg.Pi.Queue) > 0 && dbg.atBreakpoint(dbg.Pi.Queue[0].Proc) {
+			fmt.Fprintln(dbg.stdout, "break")
+			return false
+		}
+	}
+	return true
+}
+
+// Deliver delivers messages and reports if no watchpoint was hit.
+func (dbg *debugger) Deliver() bool {
+	hit := false
+	for _, m := range dbg.Pi.Ether {
+		if dbg.Watched[m.Channel] {
+			fmt.Fprintf(dbg.stdout, "watch: delivering %v->%v to %v listeners\n",
+				dbg.label(m.Content), dbg.label(m.Channel), len(m.Channel.Listeners))
+			hit = true
+		}
+	}
+	dbg.Pi.DeliverMessages(dbg.stdin, dbg.stdout)
+	return !hit
+}
+
+func (dbg *debugger) atBreakpoint(p *pi.Proc) bool {
+	loc := p.Location
+	return dbg.Breaks[fmt.Sprintf("%v:%v", filepath.Base(loc.Path), loc.Ln)] ||
+		dbg.Breaks[fmt.Sprintf("%v:%v", loc.Path, loc.Ln)]
+}
+
+func (dbg *debugger) label(c *pi.Channel) string {
+	if name, ok := dbg.Labels[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("%p", c)
+}
+
+func (dbg *debugger) printNext() {
+	fmt.Fprintf(dbg.stdout, "cycle %v: ", dbg.Pi.Cycle)
+	if len(dbg.Pi.Queue) == 0 {
+		fmt.Fprintf(dbg.stdout, "queue is empty, %v messages in the ether\n", len(dbg.Pi.Ether))
+		return
+	}
+	p := dbg.Pi.Queue[0].Proc
+	fmt.Fprintf(dbg.stdout, "next %v %v\n", p.Location, p.CommandName(dbg.Scopes[p]))
+}
+
+func (dbg *debugger) printRefs(arg string) {
+	i, err := strconv.Atoi(arg)
+	if err != nil || i < 0 || i >= len(dbg.Pi.Queue) {
+		fmt.Fprintf(dbg.stdout, "no node %v in the queue\n", arg)
+		return
+	}
+	node := dbg.Pi.Queue[i]
+	names := dbg.Scopes[node.Proc]
+	for j, c := range node.Refs {
+		// Skip IO channels that were not shifted by dereferencing.
+		if c.IOIndex == j {
+			continue
+		}
+		fmt.Fprintf(dbg.stdout, "%v_ %v %p\n", j, names[j], c)
+	}
+}
+
+// Write the state as a DOT graph to a file.
+func (dbg *debugger) writeGraph(path string) {
+	if len(path) == 0 {
+		fmt.Fprintln(dbg.stdout, "missing file name")
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(dbg.stdout, err)
+		return
+	}
+	dbg.Pi.WriteDOT(f, dbg.Scopes)
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(dbg.stdout, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+func fmtCmd(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false,
+		"List files that are not formatted and exit with status 1 if there are any.")
+	write := flags.Bool("w", false,
+		"Write the result to the source files instead of standard output.")
+	flags.Parse(args)
+
+	status := 0
+	for _, path := range flags.Args() {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		source := string(bytes)
+		formatted, err := pi.Format(source, path)
+		if err != nil {
+			pi.WriteDiagnostics(os.Stderr, err, options.ReadFile)
+			status = 1
+			continue
+		}
+		switch {
+		case *check:
+			if formatted != source {
+				fmt.Println(path)
+				status = 1
+			}
+		case *write:
+			if formatted != source {
+				if err := ioutil.WriteFile(path, []byte(formatted), 0644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					status = 1
+				}
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	return status
+}
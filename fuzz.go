@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// Run a program with random execution orders and compare the output with the
+// output of the default order.
+func fuzzCmd(args []string) int {
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	addIncludeFlag(flags)
+	runs := flags.Int("runs", 100,
+		"Number of randomized runs.")
+	seed := flags.Int64("seed", 1,
+		"Seed of the first run (run i uses seed+i).")
+	maxCycles := flags.Uint64("max_cycles", 10000000,
+		"Stop a run after this number of cycles.")
+	stdinStr := flags.String("stdin", "",
+		"Override standard input.")
+	stdinAddStr := flags.String("stdin_add", "",
+		"Append to standard input.")
+	diagnostics := flags.String("diagnostics", "text",
+		"Format of compile errors (text or json).")
+	flags.Parse(args)
+
+	program, err := options.Compile(flags.Args()...)
+	if err != nil {
+		exitDiagnostics(err, *diagnostics)
+	}
+	printWarnings(program.Warnings, *diagnostics)
+	// Every run gets the same input.
+	bytes, err := ioutil.ReadAll(stdinReader(os.Stdin, *stdinStr, *stdinAddStr))
+	if err != nil {
+		exit(err)
+	}
+	stdin := string(bytes)
+
+	want, ok := runCycles(program.Start(), stdin, *maxCycles)
+	if !ok {
+		fmt.Printf("the program does not end within %v cycles\n", *maxCycles)
+		return 1
+	}
+	failed := 0
+	for i := 0; i < *runs; i++ {
+		s := *seed + int64(i)
+		state := program.Start()
+		state.Sched = pi.NewRandomScheduler(s)
+		got, ok := runCycles(state, stdin, *maxCycles)
+		if !ok {
+			fmt.Printf("--- seed %v: timeout after %v cycles\n%v", s, *maxCycles, diff(want, got))
+			failed++
+		} else if got != want {
+			fmt.Printf("--- seed %v: different output\n%v", s, diff(want, got))
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("FAIL (%v of %v runs differ; reproduce with pi run -sched=random -seed=N)\n",
+			failed, *runs)
+		return 1
+	}
+	fmt.Printf("ok (%v runs)\n", *runs)
+	return 0
+}
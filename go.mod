@@ -0,0 +1,3 @@
+module github.com/bergwerf/pi-language
+
+go 1.16
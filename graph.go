@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// Write a graph of a program in the DOT language.
+func graphCmd(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	addIncludeFlag(flags)
+	kind := flags.String("kind", "tree",
+		"Kind of graph: tree (processes), flow (channels) or state (runtime snapshot).")
+	cycles := flags.Uint64("cycles", 0,
+		"Run this number of cycles before writing the state graph.")
+	stdinStr := flags.String("stdin", "",
+		"Override standard input (state graph).")
+	stdinAddStr := flags.String("stdin_add", "",
+		"Append to standard input (state graph).")
+	diagnostics := flags.String("diagnostics", "text",
+		"Format of compile errors (text or json).")
+	flags.Parse(args)
+
+	program, err := options.Compile(flags.Args()...)
+	if err != nil {
+		exitDiagnostics(err, *diagnostics)
+	}
+	printWarnings(program.Warnings, *diagnostics)
+	switch *kind {
+	case "tree":
+		pi.WriteTreeDOT(os.Stdout, program.Core, pi.Scopes(program.Core))
+	case "flow":
+		pi.WriteFlowDOT(os.Stdout, program.Core, pi.Scopes(program.Core))
+	case "state":
+		// The output of the program is written to stderr.
+		state := program.Start()
+		if *cycles > 0 {
+			err := state.RunLimits(context.Background(), stdinReader(os.Stdin, *stdinStr, *stdinAddStr),
+				os.Stderr, pi.Limits{MaxCycles: *cycles})
+			if _, ok := err.(*pi.LimitError); err != nil && !ok {
+				exit(err)
+			}
+		}
+		state.WriteDOT(os.Stdout, program.Scopes())
+	default:
+		exit(fmt.Errorf("unknown graph kind %v", *kind))
+	}
+	return 0
+}
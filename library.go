@@ -0,0 +1,47 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// The standard library for #attach: <file.pi>
+//
+//go:embed examples/lib/*.pi
+var library embed.FS
+
+// Options with which commands load source files
+var options pi.Options
+
+// Set up the search path from PI_PATH and the embedded standard library.
+func initLibrary() {
+	options.Library, _ = fs.Sub(library, "examples/lib")
+	options.SearchPath = filepath.SplitList(os.Getenv("PI_PATH"))
+}
+
+// Directories of -I flags are searched before the directories in PI_PATH. The
+// value is the number of -I flags.
+type includeFlag int
+
+func (n *includeFlag) String() string {
+	return strings.Join(options.SearchPath[:*n], string(filepath.ListSeparator))
+}
+
+func (n *includeFlag) Set(dir string) error {
+	path := append(options.SearchPath[:*n:*n], dir)
+	options.SearchPath = append(path, options.SearchPath[*n:]...)
+	*n++
+	return nil
+}
+
+// Add the -I flag to a command that loads source files.
+func addIncludeFlag(flags *flag.FlagSet) {
+	flags.Var(new(includeFlag), "I",
+		"Search attached files in this directory (before PI_PATH; can be repeated).")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+func lintCmd(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	addIncludeFlag(flags)
+	disable := flags.String("disable", "",
+		fmt.Sprintf("Comma separated rules to disable (%v).", strings.Join(pi.LintRules, ", ")))
+	attached := flags.Bool("attached", false,
+		"Also report warnings in attached files.")
+	diagnostics := flags.String("diagnostics", "text",
+		"Format of compile errors and warnings (text or json).")
+	flags.Parse(args)
+
+	disabled := pi.MakeSet()
+	for _, rule := range strings.Split(*disable, ",") {
+		if rule = strings.TrimSpace(rule); len(rule) > 0 {
+			disabled.Add(rule)
+		}
+	}
+	files := make(map[string]bool)
+	for _, file := range flags.Args() {
+		path, _ := filepath.Abs(file)
+		files[path] = true
+	}
+
+	a := options.AnalyzeFiles(ioutil.ReadFile, flags.Args()...)
+	if len(a.Errors) > 0 {
+		exitDiagnostics(a.Errors, *diagnostics)
+	}
+	warnings := pi.ErrorList{}
+	for _, err := range a.Lint() {
+		d := err.(*pi.Diagnostic)
+		if !disabled.Contains(d.Code) && (*attached || files[d.Span.Start.Path]) {
+			warnings = append(warnings, d)
+		}
+	}
+	if len(warnings) == 0 {
+		return 0
+	}
+	if *diagnostics == "json" {
+		pi.WriteDiagnosticsJSON(os.Stdout, warnings)
+	} else {
+		pi.WriteDiagnostics(os.Stdout, warnings, options.ReadFile)
+	}
+	return 1
+}
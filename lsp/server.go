@@ -0,0 +1,336 @@
+// Package lsp implements a language server for PI over the Language Server
+// Protocol. Documents are analyzed with the PI parser; every open document is
+// treated as the main file of a program (including the files it attaches).
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// Server is a language server connection.
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	docs      map[string]string // Content of open documents by path
+	opts      pi.Options
+	published map[string][]string // Files with diagnostics by open document
+	shutdown  bool
+}
+
+// Serve handles requests from in until the client sends exit. Attached files
+// are found with opts.
+func Serve(in io.Reader, out io.Writer, opts pi.Options) error {
+	s := &Server{bufio.NewReader(in), out, make(map[string]string), opts,
+		make(map[string][]string), false}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			s.fail(nil, codeParseError, err.Error())
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue // Notification
+		}
+		if err == errMethodNotFound {
+			s.fail(msg.ID, codeMethodNotFound, fmt.Sprintf("unknown method %v", msg.Method))
+		} else if err != nil {
+			s.fail(msg.ID, codeInvalidParams, err.Error())
+		} else {
+			s.write(response{"2.0", msg.ID, result})
+		}
+	}
+}
+
+var errMethodNotFound = errors.New("method not found")
+
+// Handle a request or notification and return the result.
+func (s *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // Full document sync
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+			},
+			"serverInfo": map[string]string{"name": "pi"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		path := uriToPath(p.TextDocument.URI)
+		s.docs[path] = p.TextDocument.Text
+		s.publish(path)
+		return nil, nil
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		path := uriToPath(p.TextDocument.URI)
+		if n := len(p.ContentChanges); n > 0 {
+			s.docs[path] = p.ContentChanges[n-1].Text
+		}
+		s.publish(path)
+		return nil, nil
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		path := uriToPath(p.TextDocument.URI)
+		delete(s.docs, path)
+		for _, file := range append(s.published[path], path) {
+			s.notify("textDocument/publishDiagnostics",
+				publishDiagnosticsParams{pathToURI(file), []Diagnostic{}})
+		}
+		delete(s.published, path)
+		return nil, nil
+	case "textDocument/definition", "textDocument/references", "textDocument/hover":
+		var p positionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		path := uriToPath(p.TextDocument.URI)
+		a := s.analyze(path)
+		loc := s.loc(path, p.Position)
+		switch msg.Method {
+		case "textDocument/definition":
+			return s.definition(a, loc), nil
+		case "textDocument/references":
+			return s.references(a, loc, p.Context.IncludeDeclaration), nil
+		default:
+			return s.hover(a, loc), nil
+		}
+	}
+	return nil, errMethodNotFound
+}
+
+// Analyze the program with the given main file.
+func (s *Server) analyze(path string) *pi.Analysis {
+	return s.opts.AnalyzeFiles(s.readFile, path)
+}
+
+// Read an open document or a file.
+func (s *Server) readFile(path string) ([]byte, error) {
+	if doc, ok := s.docs[path]; ok {
+		return []byte(doc), nil
+	}
+	return s.opts.ReadFile(path)
+}
+
+// Publish the diagnostics of a document and the files it attaches. Files that
+// no longer have diagnostics are cleared.
+func (s *Server) publish(path string) {
+	a := s.analyze(path)
+	diags := map[string][]Diagnostic{path: {}}
+	if len(a.Errors) > 0 {
+		for _, d := range pi.Diagnostics(a.Errors) {
+			// Errors without a location are shown at the start of the document.
+			file := d.Span.Start.Path
+			if len(file) == 0 {
+				file = path
+			}
+			message := d.Message
+			if len(d.Suggestions) > 0 {
+				quoted := make([]string, len(d.Suggestions))
+				for i, name := range d.Suggestions {
+					quoted[i] = strconv.Quote(name)
+				}
+				message += fmt.Sprintf(" (did you mean %v?)", strings.Join(quoted, " or "))
+			}
+			diag := Diagnostic{s.rangeOf(d.Span), int(d.Severity) + 1, d.Code, "pi", message, nil}
+			for _, r := range d.Related {
+				diag.RelatedInformation = append(diag.RelatedInformation,
+					DiagnosticRelatedInformation{s.location(r.Span), r.Message})
+			}
+			diags[file] = append(diags[file], diag)
+		}
+	}
+	for _, file := range s.published[path] {
+		if _, ok := diags[file]; !ok {
+			diags[file] = []Diagnostic{}
+		}
+	}
+	files := make([]string, 0, len(diags))
+	for file := range diags {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	published := make([]string, 0)
+	for _, file := range files {
+		s.notify("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{pathToURI(file), diags[file]})
+		if file != path && len(diags[file]) > 0 {
+			published = append(published, file)
+		}
+	}
+	s.published[path] = published
+}
+
+func (s *Server) definition(a *pi.Analysis, loc pi.Loc) interface{} {
+	ref := a.ReferenceAt(loc)
+	if ref == nil || ref.Def == nil {
+		return nil
+	}
+	return s.location(ref.Def.Span)
+}
+
+func (s *Server) references(a *pi.Analysis, loc pi.Loc, declaration bool) []Location {
+	locations := make([]Location, 0)
+	ref := a.ReferenceAt(loc)
+	if ref == nil || ref.Def == nil {
+		return locations
+	}
+	for _, r := range a.References(ref.Def) {
+		if declaration || r.Span != ref.Def.Span {
+			locations = append(locations, s.location(r.Span))
+		}
+	}
+	return locations
+}
+
+// Show the core commands of the line and the binding of the name under the
+// cursor.
+func (s *Server) hover(a *pi.Analysis, loc pi.Loc) interface{} {
+	core := a.CoreAt(loc.Path, loc.Ln)
+	if len(core) == 0 {
+		return nil
+	}
+	value := fmt.Sprintf("```\n%v\n```", core)
+	if ref := a.ReferenceAt(loc); ref != nil && ref.Def != nil {
+		value += fmt.Sprintf("\n\n`%v` is bound at %v", ref.Name, ref.Def.Start)
+	}
+	return hover{markupContent{"markdown", value}, nil}
+}
+
+// Read a message.
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *Server) write(v interface{}) {
+	body, _ := json.Marshal(v)
+	fmt.Fprintf(s.out, "Content-Length: %v\r\n\r\n%s", len(body), body)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.write(notification{"2.0", method, params})
+}
+
+func (s *Server) fail(id *json.RawMessage, code int, message string) {
+	s.write(errorResponse{"2.0", id, responseError{code, message}})
+}
+
+// Return a line of a document or file (without line break).
+func (s *Server) line(path string, ln int) string {
+	bytes, err := s.readFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(bytes), "\n")
+	if ln < 1 || ln > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[ln-1], "\r")
+}
+
+// Convert a location to an LSP position.
+func (s *Server) position(loc pi.Loc) Position {
+	if loc.Ln < 1 {
+		return Position{0, 0}
+	}
+	line := s.line(loc.Path, loc.Ln)
+	col := loc.Col - 1
+	if col > len(line) {
+		col = len(line)
+	} else if col < 0 {
+		col = 0
+	}
+	return Position{loc.Ln - 1, len(utf16.Encode([]rune(line[:col])))}
+}
+
+// Convert an LSP position to a location.
+func (s *Server) loc(path string, pos Position) pi.Loc {
+	line := s.line(path, pos.Line+1)
+	units := 0
+	for i, r := range line {
+		if units >= pos.Character {
+			return pi.Loc{Path: path, Ln: pos.Line + 1, Col: i + 1}
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return pi.Loc{Path: path, Ln: pos.Line + 1, Col: len(line) + 1}
+}
+
+func (s *Server) rangeOf(span pi.Span) Range {
+	return Range{s.position(span.Start), s.position(span.End)}
+}
+
+func (s *Server) location(span pi.Span) Location {
+	return Location{pathToURI(span.Start.Path), s.rangeOf(span)}
+}
+
+// Files in the library have pi-lib: URIs.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err == nil && u.Scheme == "pi-lib" {
+		return pi.LibraryPrefix + u.Opaque
+	} else if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if strings.HasPrefix(path, pi.LibraryPrefix) {
+		return (&url.URL{Scheme: "pi-lib", Opaque: path[len(pi.LibraryPrefix):]}).String()
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
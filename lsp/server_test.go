@@ -0,0 +1,71 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// Frame messages with a content length header.
+func frame(msgs ...interface{}) string {
+	var b strings.Builder
+	for _, msg := range msgs {
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(&b, "Content-Length: %v\r\n\r\n%s", len(body), body)
+	}
+	return b.String()
+}
+
+// Return the published diagnostics as file:count in order.
+func published(t *testing.T, out string, dir string) string {
+	t.Helper()
+	strs := make([]string, 0)
+	for _, part := range strings.Split(out, "Content-Length: ")[1:] {
+		var msg struct {
+			Method string
+			Params publishDiagnosticsParams
+		}
+		if err := json.Unmarshal([]byte(part[strings.Index(part, "{"):]), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Method == "textDocument/publishDiagnostics" {
+			rel, _ := filepath.Rel(dir, uriToPath(msg.Params.URI))
+			strs = append(strs, fmt.Sprintf("%v:%v", rel, len(msg.Params.Diagnostics)))
+		}
+	}
+	return strings.Join(strs, " ")
+}
+
+// Diagnostics in attached files are published and cleared.
+func TestPublishAttached(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.pi"), []byte("x<-y."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(dir, "a.pi"))
+	open := map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen",
+		"params": didOpenParams{textDocumentItem{uri, "#attach: b.pi\n"}}}
+	change := map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didChange",
+		"params": map[string]interface{}{"textDocument": textDocumentIdentifier{uri},
+			"contentChanges": []map[string]string{{"text": "+x;x->x.\n"}}}}
+	var out bytes.Buffer
+	if err := Serve(strings.NewReader(frame(open, change)), &out, pi.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := published(t, out.String(), dir), "a.pi:0 b.pi:1 a.pi:0 b.pi:0"; got != want {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestLibraryURI(t *testing.T) {
+	uri := pathToURI(pi.LibraryPrefix + "nat.pi")
+	if uri != "pi-lib:nat.pi" || uriToPath(uri) != pi.LibraryPrefix+"nat.pi" {
+		t.Errorf("pathToURI(<lib>/nat.pi) = %v, uriToPath of it = %v", uri, uriToPath(uri))
+	}
+}
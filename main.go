@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -8,115 +11,468 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/bergwerf/pi-language/lsp"
+	"github.com/bergwerf/pi-language/pi"
 )
 
+// Subcommands. Arguments without a known subcommand are passed to run.
+// Each command returns the exit status.
+var commands = map[string]func(args []string) int{
+	"run":   runCmd,
+	"build": buildCmd,
+	"repl":  replCmd,
+	"lsp":   lspCmd,
+	"fmt":   fmtCmd,
+	"lint":  lintCmd,
+	"fuzz":  fuzzCmd,
+	"check": checkCmd,
+	"graph": graphCmd,
+	"test":  testCmd,
+}
+
 func main() {
-	stdinStr := flag.String("stdin", "",
+	initLibrary()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+	os.Exit(runCmd(os.Args[1:]))
+}
+
+func runCmd(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	addIncludeFlag(flags)
+	stdinStr := flags.String("stdin", "",
 		"Override standard input.")
-	stdinAddStr := flag.String("stdin_add", "",
+	stdinAddStr := flags.String("stdin_add", "",
 		"Append to standard input.")
-	writeCoreFile := flag.String("write_core", "",
+	writeCoreFile := flags.String("write_core", "",
 		"Output core language.")
-	writeOptCoreFile := flag.String("write_opt_core", "",
+	writeOptCoreFile := flags.String("write_opt_core", "",
 		"Output optimized core language.")
+	loadCoreFile := flags.String("load_core", "",
+		"Run a program in the core language instead of source files.")
+	backend := flags.String("backend", "cycle",
+		"Runtime backend (cycle or goroutine).")
+	traceFile := flags.String("trace", "",
+		"Write runtime events as JSON lines (cycle backend).")
+	debugMode := flags.Bool("debug", false,
+		"Step through the program (stdin is only read from -stdin/-stdin_add).")
+	reportBlocked := flags.Bool("report_blocked", false,
+		"Print all listeners that are blocked when the program ends (cycle backend).")
+	doneName := flags.String("done", "",
+		"Exit with status 2 if no message is sent on this channel (cycle backend).")
+	diagnostics := flags.String("diagnostics", "text",
+		"Format of compile errors (text or json).")
+	schedName := flags.String("sched", "fifo",
+		"Scheduler (fifo, random or adversarial; cycle backend).")
+	seed := flags.Int64("seed", 1,
+		"Seed of the random scheduler and of delays and drops.")
+	delay := flags.Float64("delay", 0,
+		"Probability that a message is delayed to the next cycle.")
+	drop := flags.Float64("drop", 0,
+		"Probability that a message is dropped.")
+	schedule := flags.String("schedule", "",
+		"Replay a schedule of choices found by pi check (cycle backend).")
+	profileFile := flags.String("profile", "",
+		"Write a pprof profile to this file and a text report to the file with .txt appended "+
+			"(cycle backend).")
+	configFile := flags.String("config", "",
+		"Read limits from a JSON run config (flags take precedence).")
+	maxCycles := flags.Uint64("max_cycles", 0,
+		"Stop after this number of cycles (cycle backend).")
+	maxSteps := flags.Uint64("max_steps", 0,
+		"Stop after executing this number of nodes (cycle backend).")
+	maxChannels := flags.Uint64("max_channels", 0,
+		"Stop after creating this number of channels (cycle backend).")
+	timeout := flags.Duration("timeout", 0,
+		"Stop after this duration.")
+	listDeps := flags.Bool("list_deps", false,
+		"Print every file in load order with the files it attaches and exit.")
+
+	flags.Parse(args)
+	limits := pi.Limits{}
+	if len(*configFile) > 0 {
+		if err := readRunConfig(*configFile, &limits); err != nil {
+			exit(err)
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max_cycles":
+			limits.MaxCycles = *maxCycles
+		case "max_steps":
+			limits.MaxSteps = *maxSteps
+		case "max_channels":
+			limits.MaxChannels = *maxChannels
+		case "timeout":
+			limits.Timeout = *timeout
+		}
+	})
+
+	if *listDeps {
+		deps, err := options.Dependencies(flags.Args()...)
+		if err != nil {
+			exitDiagnostics(err, *diagnostics)
+		}
+		for _, d := range deps {
+			fmt.Printf("%v:", relativePath(d.Path))
+			for _, path := range d.Attach {
+				fmt.Printf(" %v", relativePath(path))
+			}
+			fmt.Println()
+		}
+		return 0
+	}
 
-	flag.Parse()
+	if (*reportBlocked || len(*doneName) > 0) && (*debugMode || *backend == "goroutine") {
+		exit(fmt.Errorf("-report_blocked and -done cannot be used with -debug or -backend=goroutine"))
+	}
 
 	var stdin io.Reader
 	stdin = os.Stdin
-	if len(*stdinStr) != 0 {
-		stdin = strings.NewReader(*stdinStr)
-	}
-	if len(*stdinAddStr) != 0 {
-		stdin = io.MultiReader(stdin, strings.NewReader(*stdinAddStr))
+	if *debugMode {
+		stdin = strings.NewReader("")
 	}
+	stdin = stdinReader(stdin, *stdinStr, *stdinAddStr)
 
-	// Parse all files given by the command line arguments.
-	stack := make([]string, 0)
-	tokens := make([]Token, 0)
-	global := MakeSet() // Global names
-	loaded := MakeSet() // Already parsed files
+	// Compile all files given by the command line arguments.
+	var program *pi.Program
+	var err error
+	if len(*loadCoreFile) > 0 {
+		program, err = pi.LoadCore(*loadCoreFile)
+	} else if flags.NArg() == 1 && strings.HasSuffix(flags.Arg(0), ".pib") {
+		program, err = readBytecode(flags.Arg(0))
+	} else {
+		program, err = options.Compile(flags.Args()...)
+	}
+	if err != nil {
+		exitDiagnostics(err, *diagnostics)
+	}
+	printWarnings(program.Warnings, *diagnostics)
 
-	for _, arg := range flag.Args() {
-		path, _ := filepath.Abs(arg)
-		stack = append(stack, path)
+	// Write unoptimized core.
+	if len(*writeCoreFile) > 0 {
+		if program.Core == nil {
+			exit(fmt.Errorf("-write_core needs source files (use -write_opt_core)"))
+		}
+		if err := writeFile(*writeCoreFile, pi.ProcString(program.Core)+"\n"); err != nil {
+			exit(err)
+		}
 	}
 
-	for len(stack) > 0 {
-		var path string
-		path, stack = stack[len(stack)-1], stack[:len(stack)-1]
-		if loaded.Contains(path) {
-			continue
+	// Write optimized core.
+	if len(*writeOptCoreFile) > 0 {
+		if err := writeFile(*writeOptCoreFile, pi.ProcString(program.Proc)+"\n"); err != nil {
+			exit(err)
 		}
-		loaded.Add(path)
+	}
 
-		// Try to read file.
-		bytes, err := ioutil.ReadFile(path)
+	// Record trace.
+	var trace *traceWriter
+	if len(*traceFile) > 0 {
+		if trace, err = createTrace(*traceFile); err != nil {
+			exit(err)
+		}
+		trace.tracer.Scopes = program.Scopes()
+		program.Trace = trace.tracer
+	}
+	// Close the trace before returning the exit status (exit would skip a
+	// deferred flush).
+	stop := func(status int, err error) int {
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if trace != nil {
+			if err := trace.Close(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				if status == 0 {
+					status = 1
+				}
+			}
 		}
+		return status
+	}
+
+	// Watch done channel.
+	var scopes map[*pi.Proc][]string
+	if *reportBlocked || len(*doneName) > 0 {
+		scopes = program.Scopes()
+	}
+	done := &pi.SendWatch{Name: *doneName, Scopes: scopes}
+	if len(*doneName) > 0 {
+		program.Trace = addTracer(program.Trace, done)
+	}
+	listen := &pi.ListenWatch{}
+	if *reportBlocked {
+		program.Trace = addTracer(program.Trace, listen)
+	}
 
-		// Extract directives.
-		attachAdd, globalAdd, offset, source := ExtractDirectives(string(bytes))
-		global.AddAll(castStrSliceToInterface(globalAdd)...)
+	// Profile program.
+	var profiler *pi.Profiler
+	if len(*profileFile) > 0 {
+		profiler = pi.NewProfiler(program.Proc)
+		profiler.Scopes = program.Scopes()
+		program.Trace = addTracer(program.Trace, profiler)
+	}
 
-		// Add attached files relative to this file.
-		for _, attachment := range attachAdd {
-			abs, _ := filepath.Abs(filepath.Join(filepath.Dir(path), attachment))
-			stack = append(stack, abs)
+	// Run program.
+	if *debugMode {
+		debug(program, os.Stdin, stdin, os.Stdout)
+		return stop(0, nil)
+	}
+	ctx := context.Background()
+	if *backend == "goroutine" {
+		if limits.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+			defer cancel()
+		}
+		if err := program.RunGoroutines(ctx, stdin, os.Stdout); err == context.DeadlineExceeded {
+			return stop(3, fmt.Errorf("stopped by timeout=%v", limits.Timeout))
+		} else if err != nil {
+			return stop(1, err)
+		}
+		return stop(0, nil)
+	}
+	state := program.Start()
+	if len(*schedule) > 0 {
+		choices, err := pi.ParseSchedule(*schedule)
+		if err != nil {
+			return stop(1, err)
 		}
+		state.Sched = &pi.ReplayScheduler{Choices: choices}
+	} else if *schedName != "fifo" || *delay > 0 || *drop > 0 {
+		if state.Sched, err = pi.NewScheduler(*schedName, *seed, *delay, *drop); err != nil {
+			return stop(1, err)
+		}
+	}
+	err = state.RunLimits(ctx, stdin, os.Stdout, limits)
+	if profiler != nil {
+		if err := writeProfile(profiler, *profileFile); err != nil {
+			return stop(1, err)
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*pi.LimitError); !ok {
+			return stop(1, err)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		if blocked := listen.Blocked(); *reportBlocked && len(blocked) > 0 {
+			pi.WriteBlockedReport(os.Stderr, blocked, scopes)
+		}
+		return stop(3, nil)
+	}
 
-		// Add tokens in this file.
-		tokens = append(tokens, Tokenize(source, Loc{path, offset + 1, 0}, true)...)
+	// Report blocked listeners.
+	if blocked := listen.Blocked(); *reportBlocked && len(blocked) > 0 {
+		pi.WriteBlockedReport(os.Stderr, blocked, scopes)
 	}
+	if len(*doneName) > 0 && !done.Fired {
+		return stop(2, fmt.Errorf("no message was sent on %v", *doneName))
+	}
+	return stop(0, nil)
+}
+
+func buildCmd(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	addIncludeFlag(flags)
+	outFile := flags.String("o", "out.pib",
+		"Output file.")
+	diagnostics := flags.String("diagnostics", "text",
+		"Format of compile errors (text or json).")
+	flags.Parse(args)
 
-	// Wrap all processes in globally defined names.
-	full := make([]Token, 0, len(tokens)+2*len(global)+2)
-	for v := range global {
-		full = append(full, Token{Loc{}, fmt.Sprintf("+%v", v)}, Token{Loc{}, ";"})
+	program, err := options.Compile(flags.Args()...)
+	if err != nil {
+		exitDiagnostics(err, *diagnostics)
+	}
+	printWarnings(program.Warnings, *diagnostics)
+	out, err := os.Create(*outFile)
+	if err != nil {
+		exit(err)
+	}
+	if err := program.WriteBytecode(out); err != nil {
+		exit(err)
+	}
+	if err := out.Close(); err != nil {
+		exit(err)
 	}
-	full = append(full, Token{Loc{}, "("})
-	full = append(full, tokens...)
-	full = append(full, Token{Loc{}, ")"})
+	return 0
+}
 
-	// Parse program.
-	err := ErrorList([]error{})
-	proc, unparsed := Parse(full, ioChannelOffset, copyStrIntMap(nil), &err)
-	if len(unparsed) > 0 {
-		fmt.Printf("%v tokens were not parsed\n", len(unparsed))
-		return
-	} else if len(err) != 0 {
-		for _, e := range err {
-			println(e.Error())
-		}
-		return
+// Serve the language server protocol over stdio.
+func lspCmd(args []string) int {
+	if err := lsp.Serve(os.Stdin, os.Stdout, options); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	return 0
+}
 
-	// Write unoptimized core.
-	if len(*writeCoreFile) > 0 {
-		out, _ := os.Create(*writeCoreFile)
-		out.WriteString(ProcString(proc))
+// Add a tracer to an optional tracer.
+func addTracer(tracer pi.Tracer, add pi.Tracer) pi.Tracer {
+	if tracer == nil {
+		return add
+	}
+	return pi.MultiTracer{tracer, add}
+}
+
+// Write the pprof profile to path and the text report to path.txt.
+func writeProfile(profiler *pi.Profiler, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WritePprof(out); err != nil {
 		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	report, err := os.Create(path + ".txt")
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(report)
+	if err := profiler.WriteReport(buf); err != nil {
+		report.Close()
+		return err
 	}
+	if err := buf.Flush(); err != nil {
+		report.Close()
+		return err
+	}
+	return report.Close()
+}
 
-	// Optimize program.
-	proc = Optimize(proc)
+// A JSON trace that is written to a file through a buffer.
+type traceWriter struct {
+	out    *os.File
+	buf    *bufio.Writer
+	tracer *pi.JSONTracer
+}
 
-	// Write optimized core.
-	if len(*writeOptCoreFile) > 0 {
-		out, _ := os.Create(*writeOptCoreFile)
-		out.WriteString(ProcString(proc))
-		out.WriteString("\n")
-		out.Close()
+func createTrace(path string) (*traceWriter, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, err
 	}
+	buf := bufio.NewWriter(out)
+	return &traceWriter{out, buf, pi.NewJSONTracer(buf)}, nil
+}
 
-	// Run program.
-	pi := Pi{0, nil, nil, nil}
-	pi.Initialize(proc)
-	for len(pi.Queue)+len(pi.Ether) > 0 {
-		for len(pi.Queue) > 0 {
-			pi.RunNextNode()
+// Close flushes the trace and closes the file. Returns the first write error.
+func (t *traceWriter) Close() error {
+	err := t.tracer.Err()
+	if err == nil {
+		err = t.buf.Flush()
+	}
+	if cerr := t.out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write trace: %v", err)
+	}
+	return nil
+}
+
+// A run config is a JSON object with optional limits such as
+// {"max_cycles": 1000, "timeout": "10s"}.
+type runConfig struct {
+	MaxCycles   uint64 `json:"max_cycles"`
+	MaxSteps    uint64 `json:"max_steps"`
+	MaxChannels uint64 `json:"max_channels"`
+	Timeout     string `json:"timeout"`
+}
+
+func readRunConfig(path string, limits *pi.Limits) error {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config runConfig
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	limits.MaxCycles = config.MaxCycles
+	limits.MaxSteps = config.MaxSteps
+	limits.MaxChannels = config.MaxChannels
+	if len(config.Timeout) > 0 {
+		if limits.Timeout, err = time.ParseDuration(config.Timeout); err != nil {
+			return fmt.Errorf("%v: %v", path, err)
 		}
-		pi.DeliverMessages(stdin, os.Stdout)
 	}
+	return nil
+}
+
+func readBytecode(path string) (*pi.Program, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return pi.ReadBytecode(in)
+}
+
+// Apply the -stdin and -stdin_add flags to the standard input.
+func stdinReader(stdin io.Reader, override string, add string) io.Reader {
+	if len(override) != 0 {
+		stdin = strings.NewReader(override)
+	}
+	if len(add) != 0 {
+		stdin = io.MultiReader(stdin, strings.NewReader(add))
+	}
+	return stdin
+}
+
+// Return a path relative to the working directory if it is below it.
+func relativePath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(path) {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+func writeFile(path string, content string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out.WriteString(content)
+	return out.Close()
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// Print warnings of the compiler in the given format.
+func printWarnings(warnings pi.ErrorList, format string) {
+	if len(warnings) == 0 {
+		return
+	} else if format == "json" {
+		pi.WriteDiagnosticsJSON(os.Stderr, warnings)
+	} else {
+		pi.WriteDiagnostics(os.Stderr, warnings, options.ReadFile)
+	}
+}
+
+// Print compile errors in the given format and exit.
+func exitDiagnostics(err error, format string) {
+	if format == "json" {
+		pi.WriteDiagnosticsJSON(os.Stderr, err)
+	} else {
+		pi.WriteDiagnostics(os.Stderr, err, options.ReadFile)
+	}
+	os.Exit(1)
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run the test binary as pi if PI_MAIN is set (see runMain).
+func TestMain(m *testing.M) {
+	if len(os.Getenv("PI_MAIN")) > 0 {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// Run pi with the given arguments. Returns the exit status and stderr.
+func runMain(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "PI_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), stderr.String()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stderr.String()
+}
+
+// The tests of the examples pass.
+func TestExamples(t *testing.T) {
+	cases, err := findTests([]string{"examples"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no tests in examples")
+	}
+	for _, c := range cases {
+		if err := runTest(c, 10000000); err != nil {
+			t.Errorf("--- FAIL: %v\n%v", c.Name, err)
+		}
+	}
+}
+
+// The trace is complete when the program ends with an error.
+func TestRunTraceExit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trace.json")
+	status := runCmd([]string{"-trace", path, "-profile", filepath.Join(dir, "missing", "profile"),
+		"examples/hello_world.pi"})
+	if status != 1 {
+		t.Errorf("status %v, want 1", status)
+	}
+	trace, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(trace, []byte("}\n")) || !bytes.Contains(trace, []byte(`"type":"write"`)) {
+		t.Errorf("trace is incomplete:\n%s", trace)
+	}
+}
+
+// The unoptimized core cannot be written for bytecode.
+func TestRunWriteCoreBytecode(t *testing.T) {
+	dir := t.TempDir()
+	pib := filepath.Join(dir, "hello.pib")
+	if status, stderr := runMain(t, "build", "-o", pib, "examples/hello_world.pi"); status != 0 {
+		t.Fatalf("build: status %v\n%v", status, stderr)
+	}
+	status, stderr := runMain(t, "run", "-write_core", filepath.Join(dir, "hello.core"), pib)
+	if status != 1 || !strings.Contains(stderr, "-write_core needs source files") {
+		t.Errorf("status %v, stderr %q", status, stderr)
+	}
+}
+
+// Flags that need the cycle backend are rejected with the other backends.
+func TestRunBackendFlags(t *testing.T) {
+	for _, args := range [][]string{{"-backend=goroutine", "-done=x"}, {"-debug", "-report_blocked"}} {
+		status, stderr := runMain(t, append(append([]string{"run"}, args...), "examples/hello_world.pi")...)
+		if status != 1 || !strings.Contains(stderr, "cannot be used with") {
+			t.Errorf("%v: status %v, stderr %q", args, status, stderr)
+		}
+	}
+}
+
+func TestParseTestDirective(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // Quoted stdin and stdout, or the error
+	}{
+		{` "a" "b"`, `"a" "b"`},
+		{` "a:b" "c"`, `"a:b" "c"`},
+		{` "a!" "!b" ! comment`, `"a!" "!b"`},
+		{` "a"`, "expected two quoted strings (stdin and stdout)"},
+		{` "a" "b" c`, `unexpected "c" after stdout`},
+	}
+	for _, test := range tests {
+		got := ""
+		if stdin, stdout, err := parseTestDirective(test.value); err != nil {
+			got = err.Error()
+		} else {
+			got = fmt.Sprintf("%q %q", stdin, stdout)
+		}
+		if got != test.want {
+			t.Errorf("parseTestDirective(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+// Every #test directive is found, and a malformed one is an error.
+func TestFindTests(t *testing.T) {
+	dir := t.TempDir()
+	source := "#test: \"a:b\" \"c\"\n#test: \"a!\" \"b\"\n<>stdout__A.\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.pi"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases, err := findTests([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(len(cases), " ", cases[0].Stdin, " ", cases[1].Stdin); got != "2 a:b a!" {
+		t.Errorf("found %v tests, want 2 with stdin a:b and a!", got)
+	}
+	source = "#test: \"a\"\n<>stdout__A.\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.pi"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findTests([]string{dir}); err == nil || !strings.Contains(err.Error(), "a.pi:1; #test:") {
+		t.Errorf("error %v for a malformed #test", err)
+	}
+}
+
+// Attach cycles are reported when a program runs.
+func TestRunAttachCycle(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.pi": "#attach: b.pi\n<>stdout__A.\n", "b.pi": "#attach: a.pi\n"}
+	for name, source := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, stderr := runMain(t, "run", filepath.Join(dir, "a.pi"))
+	if status != 0 || !strings.Contains(stderr, "attach cycle: a.pi -> b.pi -> a.pi") {
+		t.Errorf("status %v, stderr %q", status, stderr)
+	}
+}
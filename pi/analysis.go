@@ -0,0 +1,192 @@
+package pi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Analysis contains the results of loading a program for editor tools. It is
+// also available if the program has errors.
+type Analysis struct {
+	Files   []string           // Loaded files in order
+	Globals []Ident            // Global names located in their directives
+	Errors  ErrorList          // Errors in all files
+	Core    []*Proc            // Core processes (including erroneous processes)
+	Scopes  map[*Proc][]string // Names of the references of core processes
+	Refs    []Reference        // Bindings and uses of names in order
+
+	warnings ErrorList // Warnings of the loader (see Lint)
+}
+
+// Reference is an occurrence of a name. Def points to the binding of the name
+// (which is the identifier itself for bindings). For global names this is the
+// value of the #global directive. Def is nil for IO channels and unbound names.
+type Reference struct {
+	Ident
+	Def *Ident
+}
+
+// AnalyzeFiles loads, parses and desugars the given files. Files are read using read
+// such that unsaved changes can be analyzed.
+func AnalyzeFiles(read ReadFunc, files ...string) *Analysis {
+	return Options{}.AnalyzeFiles(read, files...)
+}
+
+// AnalyzeFiles is like AnalyzeFiles but finds attached files with the options.
+func (o Options) AnalyzeFiles(read ReadFunc, files ...string) *Analysis {
+	a := &Analysis{}
+	sources, err := loadFiles(o, files, MakeSet(), read, &a.Errors)
+	if err != nil {
+		a.Errors.Add(err)
+		return a
+	}
+	l := newLinker()
+	l.Link(sources, &a.Errors)
+	a.Files = filePaths(sources)
+	for _, f := range sources {
+		a.warnings = append(a.warnings, f.warnings...)
+	}
+	a.Globals = l.globals
+	a.Core = desugarGlobals(sources, a.Globals, &a.Errors)
+	a.Scopes = Scopes(a.Core)
+
+	// Resolve names in the scope of the global names of every file.
+	r := &resolver{make([]Reference, 0)}
+	for i := range a.Globals {
+		r.refs = append(r.refs, Reference{a.Globals[i], &a.Globals[i]})
+	}
+	for _, f := range sources {
+		scope := make(map[string]*Ident, len(f.scope))
+		for name, i := range f.scope {
+			scope[name] = &a.Globals[i]
+		}
+		r.processes(f.proc, scope)
+	}
+	a.Refs = r.refs
+	return a
+}
+
+// ReferenceAt returns the reference at a location (or nil). A location directly
+// after a name also refers to the name.
+func (a *Analysis) ReferenceAt(loc Loc) *Reference {
+	for i, r := range a.Refs {
+		if r.Start.Path == loc.Path && r.Start.Ln == loc.Ln &&
+			r.Start.Col <= loc.Col && loc.Col <= r.End.Col && len(r.Name) > 0 {
+			return &a.Refs[i]
+		}
+	}
+	return nil
+}
+
+// References returns all references to the given binding.
+func (a *Analysis) References(def *Ident) []Reference {
+	refs := make([]Reference, 0)
+	for _, r := range a.Refs {
+		if r.Def == def {
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// CoreAt returns the core commands of the processes on a line, one process
+// per line.
+func (a *Analysis) CoreAt(path string, ln int) string {
+	on := func(p *Proc) bool {
+		return p.Location.Path == path && p.Location.Ln == ln
+	}
+	// Print processes on the line (without children on other lines).
+	var print func(proc []*Proc) string
+	print = func(proc []*Proc) string {
+		strs := make([]string, 0, len(proc))
+		for _, p := range proc {
+			if on(p) {
+				str := p.CommandName(a.Scopes[p])
+				if children := print(p.Children); len(children) > 0 {
+					str += ";" + children
+				}
+				strs = append(strs, str)
+			}
+		}
+		if len(strs) > 1 {
+			return fmt.Sprintf("(%v)", strings.Join(strs, " "))
+		}
+		return strings.Join(strs, "")
+	}
+	// Find the first processes on the line.
+	strs := make([]string, 0)
+	var find func(proc []*Proc)
+	find = func(proc []*Proc) {
+		for _, p := range proc {
+			if on(p) {
+				strs = append(strs, print([]*Proc{p}))
+			} else {
+				find(p.Children)
+			}
+		}
+	}
+	find(a.Core)
+	return strings.Join(strs, "\n")
+}
+
+// A resolver finds the binding of every name in the surface syntax tree in the
+// same order as Desugar.
+type resolver struct {
+	refs []Reference
+}
+
+func (r *resolver) processes(proc []Process, scope map[string]*Ident) {
+	for _, p := range proc {
+		pScope := make(map[string]*Ident, len(scope))
+		for k, v := range scope {
+			pScope[k] = v
+		}
+		r.process(p, pScope)
+	}
+}
+
+func (r *resolver) process(proc Process, scope map[string]*Ident) {
+	switch p := proc.(type) {
+	case *Block:
+		r.processes(p.Body, scope)
+	case *Action:
+		r.command(p.Command, scope)
+		if p.Next != nil {
+			r.process(p.Next, scope)
+		}
+	}
+}
+
+func (r *resolver) use(names []Ident, scope map[string]*Ident) {
+	for _, v := range names {
+		if len(v.Name) > 0 {
+			r.refs = append(r.refs, Reference{v, scope[v.Name]})
+		}
+	}
+}
+
+func (r *resolver) bind(names []Ident, scope map[string]*Ident) {
+	for _, v := range names {
+		if len(v.Name) > 0 {
+			def := &Ident{v.Span, v.Name}
+			scope[v.Name] = def
+			r.refs = append(r.refs, Reference{v, def})
+		}
+	}
+}
+
+func (r *resolver) command(c Command, scope map[string]*Ident) {
+	switch c.Form {
+	case FormCreate:
+		r.bind(c.Args, scope)
+	case FormCreateSend:
+		r.bind(c.Args, scope)
+		r.use(c.Targets, scope)
+	case FormReceive, FormReceiveAll, FormTunnelReceive, FormTunnelOne, FormTunnelAll:
+		r.use(c.Targets, scope)
+		r.bind(c.Args, scope)
+	default:
+		r.use(c.Args, scope)
+		r.use(c.Targets, scope)
+	}
+}
@@ -0,0 +1,61 @@
+package pi
+
+// Surface syntax tree
+//
+// The parser produces a tree that mirrors the source: every node has the exact
+// span of its source text and commands keep the syntactic form in which they
+// were written. Desugar converts this tree into core processes.
+
+// Process is a process in the surface syntax tree (*Block or *Action).
+type Process interface {
+	Range() Span
+}
+
+// Block is a list of parallel processes in parentheses.
+type Block struct {
+	Span
+	Body []Process
+}
+
+// Action is a command followed by a continuation. Next is nil if the command
+// is terminated by a period. The span includes the continuation.
+type Action struct {
+	Span
+	Command Command
+	Next    Process
+}
+
+// Form is the syntactic form of a command.
+type Form uint8
+
+// Command forms
+const (
+	FormCreate        Form = iota // +a,b
+	FormCreateSend                // +a,b->x,y
+	FormReceive                   // a,b<-x and <-x
+	FormReceiveAll                // y<<x and <<x
+	FormSend                      // a,b->x,y and ->x
+	FormTrigger                   // <>x
+	FormForward                   // x>>a,b
+	FormTunnelSend                // a,b>->x
+	FormTunnelReceive             // a,b<-<x
+	FormTunnelOne                 // a,b<<-x
+	FormTunnelAll                 // a,b<<<x
+)
+
+// Command is a single command. Args are the names before the operator (or after
+// the + of a create) and Targets the names after the operator. Names may be
+// omitted in argument lists; such names are empty.
+type Command struct {
+	Span
+	Form    Form
+	Args    []Ident
+	Targets []Ident
+}
+
+// Ident is a name in the source. An omitted name has an empty span at the
+// position where it would be.
+type Ident struct {
+	Span
+	Name string
+}
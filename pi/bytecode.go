@@ -0,0 +1,260 @@
+package pi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Bytecode format
+//
+// A compiled program is stored as a magic number and a version followed by a
+// string table, the file and global tables (indices into the string table) and
+// the optimized process tree. All integers are varints. A process list is the
+// number of processes followed by for each process: the command byte, channel,
+// message, name, location (path, line, column) and its children.
+const (
+	bytecodeMagic   = "PIB\x00"
+	bytecodeVersion = 1
+)
+
+// WriteBytecode writes the optimized program in the bytecode format.
+func (p *Program) WriteBytecode(w io.Writer) error {
+	bw := &bytecodeWriter{bufio.NewWriter(w), make(map[string]int), nil}
+
+	// Build string table.
+	for _, s := range p.Files {
+		bw.index(s)
+	}
+	for _, s := range p.Globals {
+		bw.index(s)
+	}
+	bw.indexProc(p.Proc)
+
+	bw.w.WriteString(bytecodeMagic)
+	bw.uvarint(bytecodeVersion)
+	bw.uvarint(len(bw.strings))
+	for _, s := range bw.strings {
+		bw.uvarint(len(s))
+		bw.w.WriteString(s)
+	}
+	for _, table := range [][]string{p.Files, p.Globals} {
+		bw.uvarint(len(table))
+		for _, s := range table {
+			bw.uvarint(bw.index(s))
+		}
+	}
+	bw.proc(p.Proc)
+	return bw.w.Flush()
+}
+
+// ReadBytecode reads a program in the bytecode format. Malformed input is
+// reported as an error.
+func ReadBytecode(r io.Reader) (*Program, error) {
+	br := &bytecodeReader{bufio.NewReader(r), nil, 0, nil}
+	magic := make([]byte, len(bytecodeMagic))
+	if _, err := io.ReadFull(br.r, magic); err != nil || string(magic) != bytecodeMagic {
+		return nil, errors.New("not a PI bytecode file")
+	}
+	if v := br.uint(math.MaxInt32); br.err == nil && v != bytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %v", v)
+	}
+
+	// Read string table.
+	for i, n := 0, br.uint(maxBytecodeLength); i < n && br.err == nil; i++ {
+		br.strings = append(br.strings, br.bytes(br.uint(maxBytecodeLength)))
+	}
+
+	p := &Program{}
+	p.Files = br.stringTable()
+	p.Globals = br.stringTable()
+	p.Proc = br.proc()
+	if br.err == nil {
+		br.err = checkRefs(p.Proc, ioChannelOffset)
+	}
+	if br.err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %v", br.err)
+	}
+	return p, nil
+}
+
+// Check that all processes only use references that are in scope.
+func checkRefs(proc []*Proc, scope int) error {
+	for _, p := range proc {
+		pScope := scope
+		valid := p.Channel >= 0 && p.Channel < scope
+		switch p.Command {
+		case PINewRef:
+			valid = p.Channel == scope && p.Message == -1
+			pScope++
+		case PIDeref:
+			valid = valid && p.Message == -1
+			pScope--
+		case PISubsOne, PISubsAll:
+			valid = valid && p.Message == scope
+			pScope++
+		case PISend:
+			valid = valid && p.Message >= 0 && p.Message < scope
+		default:
+			valid = false
+		}
+		if !valid {
+			return fmt.Errorf("%v; invalid command %v", p.Location, p.CommandString())
+		}
+		if err := checkRefs(p.Children, pScope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bytecodeWriter struct {
+	w       *bufio.Writer
+	indices map[string]int
+	strings []string
+}
+
+func (bw *bytecodeWriter) index(s string) int {
+	i, ok := bw.indices[s]
+	if !ok {
+		i = len(bw.strings)
+		bw.indices[s] = i
+		bw.strings = append(bw.strings, s)
+	}
+	return i
+}
+
+func (bw *bytecodeWriter) indexProc(proc []*Proc) {
+	for _, p := range proc {
+		bw.index(p.Name)
+		bw.index(p.Location.Path)
+		bw.indexProc(p.Children)
+	}
+}
+
+func (bw *bytecodeWriter) uvarint(x int) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	bw.w.Write(buf[:binary.PutUvarint(buf, uint64(x))])
+}
+
+func (bw *bytecodeWriter) varint(x int) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	bw.w.Write(buf[:binary.PutVarint(buf, int64(x))])
+}
+
+func (bw *bytecodeWriter) proc(proc []*Proc) {
+	bw.uvarint(len(proc))
+	for _, p := range proc {
+		bw.w.WriteByte(p.Command)
+		bw.varint(p.Channel)
+		bw.varint(p.Message)
+		bw.uvarint(bw.index(p.Name))
+		bw.uvarint(bw.index(p.Location.Path))
+		bw.uvarint(p.Location.Ln)
+		bw.uvarint(p.Location.Col)
+		bw.proc(p.Children)
+	}
+}
+
+// Limits of the bytecode reader (a malformed file should not make it allocate
+// a lot of memory or recurse very deeply)
+const (
+	maxBytecodeLength = 1 << 24 // Number of strings, string bytes and processes
+	maxBytecodeDepth  = 1 << 16 // Nesting depth of processes
+)
+
+// A bytecodeReader reads values until the first error. Counts are not used to
+// preallocate memory, so the memory use is proportional to the input size.
+type bytecodeReader struct {
+	r       *bufio.Reader
+	strings []string
+	depth   int
+	err     error
+}
+
+// Read an unsigned integer that is at most max.
+func (br *bytecodeReader) uint(max int) int {
+	if br.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(br.r)
+	if err == nil && x > uint64(max) {
+		err = fmt.Errorf("integer %v out of range", x)
+	}
+	br.err = err
+	return int(x)
+}
+
+// Read a signed integer in the range [min, max].
+func (br *bytecodeReader) int(min int, max int) int {
+	if br.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(br.r)
+	if err == nil && (x < int64(min) || x > int64(max)) {
+		err = fmt.Errorf("integer %v out of range", x)
+	}
+	br.err = err
+	return int(x)
+}
+
+// Read n bytes as a string.
+func (br *bytecodeReader) bytes(n int) string {
+	if br.err != nil {
+		return ""
+	}
+	buf, err := io.ReadAll(io.LimitReader(br.r, int64(n)))
+	if err == nil && len(buf) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	br.err = err
+	return string(buf)
+}
+
+func (br *bytecodeReader) string() string {
+	i := br.uint(math.MaxInt32)
+	if br.err != nil {
+		return ""
+	} else if i >= len(br.strings) {
+		br.err = fmt.Errorf("string index %v out of range", i)
+		return ""
+	}
+	return br.strings[i]
+}
+
+func (br *bytecodeReader) stringTable() []string {
+	table := make([]string, 0)
+	for i, n := 0, br.uint(maxBytecodeLength); i < n && br.err == nil; i++ {
+		table = append(table, br.string())
+	}
+	return table
+}
+
+func (br *bytecodeReader) proc() []*Proc {
+	n := br.uint(maxBytecodeLength)
+	if br.err == nil && n > 0 && br.depth == maxBytecodeDepth {
+		br.err = errors.New("processes are nested too deeply")
+	}
+	if br.err != nil || n == 0 {
+		return nil
+	}
+	br.depth++
+	defer func() { br.depth-- }()
+	proc := make([]*Proc, 0)
+	for i := 0; i < n && br.err == nil; i++ {
+		p := &Proc{}
+		p.Command, br.err = br.r.ReadByte()
+		p.Channel = br.int(-1, math.MaxInt32)
+		p.Message = br.int(-1, math.MaxInt32)
+		p.Name = br.string()
+		p.Location.Path = br.string()
+		p.Location.Ln = br.uint(math.MaxInt32)
+		p.Location.Col = br.uint(math.MaxInt32)
+		p.Children = br.proc()
+		proc = append(proc, p)
+	}
+	return proc
+}
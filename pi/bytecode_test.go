@@ -0,0 +1,139 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Write all fields of the processes (one line per process).
+func dumpProc(b *strings.Builder, proc []*Proc, depth int) {
+	for _, p := range proc {
+		fmt.Fprintf(b, "%v%v %v %v %q %v\n", strings.Repeat(" ", depth),
+			p.Command, p.Channel, p.Message, p.Name, p.Location)
+		dumpProc(b, p.Children, depth+1)
+	}
+}
+
+func procTree(proc []*Proc) string {
+	var b strings.Builder
+	dumpProc(&b, proc, 0)
+	return b.String()
+}
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	for _, name := range examplePrograms {
+		p, err := compileExample(name)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		var buf bytes.Buffer
+		if err := p.WriteBytecode(&buf); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		q, err := ReadBytecode(&buf)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if fmt.Sprint(q.Files) != fmt.Sprint(p.Files) || fmt.Sprint(q.Globals) != fmt.Sprint(p.Globals) {
+			t.Errorf("%v: read files %v and globals %v, want %v and %v",
+				name, q.Files, q.Globals, p.Files, p.Globals)
+		}
+		if procTree(q.Proc) != procTree(p.Proc) {
+			t.Errorf("%v: read processes differ from the compiled processes", name)
+		}
+	}
+}
+
+func TestBytecodeRun(t *testing.T) {
+	p, err := compileExample("hello_world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf, out bytes.Buffer
+	p.WriteBytecode(&buf)
+	q, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Run(context.Background(), strings.NewReader(""), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Hello, World!\n" {
+		t.Errorf("output %q, want %q", out.String(), "Hello, World!\n")
+	}
+}
+
+// Encode unsigned varints.
+func uvarints(xs ...uint64) string {
+	b := make([]byte, 0)
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, x := range xs {
+		b = append(b, buf[:binary.PutUvarint(buf, x)]...)
+	}
+	return string(b)
+}
+
+// Encode a process without a name, location and children (string 0 must be
+// empty).
+func bytecodeProc(command uint8, channel, message int) string {
+	buf := make([]byte, binary.MaxVarintLen64)
+	s := string([]byte{command})
+	s += string(buf[:binary.PutVarint(buf, int64(channel))])
+	s += string(buf[:binary.PutVarint(buf, int64(message))])
+	return s + uvarints(0, 0, 0, 0)
+}
+
+func TestReadBytecodeErrors(t *testing.T) {
+	// Header with version 1, an empty string and no files or globals
+	header := bytecodeMagic + uvarints(1, 1, 0, 0, 0)
+	var deep strings.Builder
+	deep.WriteString(header)
+	for i := 0; i <= maxBytecodeDepth; i++ {
+		deep.WriteString(uvarints(1) + bytecodeProc(PINewRef, ioChannelOffset+i, -1))
+	}
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"empty", "", "not a PI bytecode file"},
+		{"magic", "PIC\x00" + uvarints(1), "not a PI bytecode file"},
+		{"version", bytecodeMagic + uvarints(2), "unsupported bytecode version 2"},
+		{"string count", bytecodeMagic + uvarints(1, 1<<40), "integer 1099511627776 out of range"},
+		{"string length", bytecodeMagic + uvarints(1, 1, 1<<62), "out of range"},
+		{"short string", bytecodeMagic + uvarints(1, 1, 10) + "abc", "unexpected EOF"},
+		{"varint overflow", bytecodeMagic + uvarints(1) + strings.Repeat("\xff", 10) + "\x01", "overflows"},
+		{"file index", bytecodeMagic + uvarints(1, 1, 0, 1, 1), "string index 1 out of range"},
+		{"process count", header + uvarints(1<<30), "out of range"},
+		{"channel", header + uvarints(1) + bytecodeProc(PISend, 1<<40, 0), "out of range"},
+		{"scope", header + uvarints(1) + bytecodeProc(PISend, ioChannelOffset, 0) + uvarints(0), "invalid command"},
+		{"command", header + uvarints(1) + bytecodeProc(9, 0, 0) + uvarints(0), "invalid command"},
+		{"nesting", deep.String(), "processes are nested too deeply"},
+	}
+	for _, test := range tests {
+		_, err := ReadBytecode(strings.NewReader(test.input))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// Every truncation of a valid file is an error.
+func TestReadBytecodeTruncated(t *testing.T) {
+	p, err := compileExample("hello_world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p.WriteBytecode(&buf)
+	for n := 0; n < buf.Len(); n++ {
+		if _, err := ReadBytecode(bytes.NewReader(buf.Bytes()[:n])); err == nil {
+			t.Fatalf("no error for the first %v of %v bytes", n, buf.Len())
+		}
+	}
+}
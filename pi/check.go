@@ -0,0 +1,366 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReplayScheduler follows a schedule of choices (such as a counterexample
+// found by Check). Nodes that do not send run first in queue order. If the
+// queue only contains sends, a choice selects the next send node. If messages
+// are delivered to multiple stdout channels in one cycle, choices select the
+// order of the writes (one choice among the remaining channels for every write
+// except the last). Missing or invalid choices are 0.
+type ReplayScheduler struct {
+	Choices []int
+	Used    int // Number of choices that were made
+}
+
+func (s *ReplayScheduler) choose(n int) int {
+	c := 0
+	if s.Used < len(s.Choices) && s.Choices[s.Used] < n && s.Choices[s.Used] > 0 {
+		c = s.Choices[s.Used]
+	}
+	s.Used++
+	return c
+}
+
+// Next returns the first node that does not send or the chosen send.
+func (s *ReplayScheduler) Next(queue []Node) int {
+	options := nextOptions(queue)
+	if len(options) == 1 {
+		return options[0]
+	}
+	return options[s.choose(len(options))]
+}
+
+// Order moves the stdout messages to the front in the chosen order.
+func (s *ReplayScheduler) Order(ether []Message) {
+	writes := writeOptions(ether)
+	if len(writes) < 2 {
+		return
+	}
+	order := make([]Message, 0, len(ether))
+	moved := MakeSet()
+	for len(writes) > 0 {
+		i := 0
+		if len(writes) > 1 {
+			i = s.choose(len(writes))
+		}
+		order = append(order, ether[writes[i]])
+		moved.Add(writes[i])
+		writes = append(writes[:i:i], writes[i+1:]...)
+	}
+	for i, m := range ether {
+		if !moved.Contains(i) {
+			order = append(order, m)
+		}
+	}
+	copy(ether, order)
+}
+
+// Fate always delivers.
+func (s *ReplayScheduler) Fate(m Message) Fate {
+	return Deliver
+}
+
+// Return the index of the first node that does not send, or the indices of all
+// sends if there is no such node. Nodes that do not send are independent of
+// all other nodes (they do not change the ether).
+func nextOptions(queue []Node) []int {
+	sends := make([]int, 0)
+	for i, n := range queue {
+		if n.Proc.Command != PISend {
+			return []int{i}
+		}
+		sends = append(sends, i)
+	}
+	return sends
+}
+
+// Return the indices of the stdout messages that are delivered in this cycle
+// (the first message of every stdout channel). Only the order of these
+// deliveries changes the output.
+func writeOptions(ether []Message) []int {
+	writes := make([]int, 0)
+	channels := MakeSet()
+	for i, m := range ether {
+		id := m.Channel.IOIndex
+		if stdoutOffset <= id && id < stdoutOffset+256 && !channels.Contains(id) {
+			channels.Add(id)
+			writes = append(writes, i)
+		}
+	}
+	return writes
+}
+
+// ParseSchedule parses comma separated choices.
+func ParseSchedule(str string) ([]int, error) {
+	choices := make([]int, 0)
+	for _, s := range strings.Split(str, ",") {
+		if s = strings.TrimSpace(s); len(s) == 0 {
+			continue
+		}
+		c, err := strconv.Atoi(s)
+		if err != nil || c < 0 {
+			return nil, fmt.Errorf("invalid choice %q in schedule", s)
+		}
+		choices = append(choices, c)
+	}
+	return choices, nil
+}
+
+// FormatSchedule formats choices for ParseSchedule.
+func FormatSchedule(choices []int) string {
+	strs := make([]string, len(choices))
+	for i, c := range choices {
+		strs[i] = strconv.Itoa(c)
+	}
+	return strings.Join(strs, ",")
+}
+
+// RunSchedule runs a program with a ReplayScheduler for at most maxCycles
+// cycles. Returns the output and false if the program did not end.
+func (p *Program) RunSchedule(stdin string, choices []int, maxCycles uint64) (string, bool) {
+	state := p.Start()
+	state.Sched = &ReplayScheduler{choices, 0}
+	var out bytes.Buffer
+	err := state.RunLimits(context.Background(), strings.NewReader(stdin), &out,
+		Limits{MaxCycles: maxCycles})
+	return out.String(), err == nil
+}
+
+// CheckResult is the result of Check.
+type CheckResult struct {
+	States   int    // Number of distinct states that were explored
+	Complete bool   // Whether all reachable states were explored
+	Output   string // Output of the default schedule
+	Schedule []int  // Schedule with a different output (nil if there is none)
+	Counter  string // Output of Schedule
+}
+
+// A state in the search of Check
+type checkState struct {
+	pi       *Pi
+	stdin    int // Number of bytes that are read
+	stdout   []byte
+	schedule []int
+}
+
+// Check explores all schedules of a program (as defined by ReplayScheduler)
+// with the given input to prove that the output does not depend on the
+// schedule or to find a schedule with a different output. States are hashed
+// such that every state is explored once. The search stops after maxStates
+// distinct states; paths that are longer than maxCycles are not explored
+// further. The counterexample is minimized by resetting choices to 0.
+func (p *Program) Check(stdin string, maxStates int, maxCycles uint64) (*CheckResult, error) {
+	result := &CheckResult{Complete: true}
+	var ok bool
+	if result.Output, ok = p.RunSchedule(stdin, nil, maxCycles); !ok {
+		return nil, fmt.Errorf("the program does not end within %v cycles", maxCycles)
+	}
+	procs := make(map[*Proc]int)
+	var number func(proc []*Proc)
+	number = func(proc []*Proc) {
+		for _, p := range proc {
+			procs[p] = len(procs)
+			number(p.Children)
+		}
+	}
+	number(p.Proc)
+
+	seen := make(map[[sha256.Size]byte]bool)
+	visit := func(s *checkState) bool {
+		key := sha256.Sum256([]byte(stateKey(s, procs)))
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		result.States++
+		return true
+	}
+	// Take a step with the given choices. States with a decision are copied.
+	step := func(s *checkState, choices []int, deliver bool) *checkState {
+		next := s
+		if len(choices) > 0 {
+			next = &checkState{s.pi.Clone(), s.stdin, s.stdout, s.schedule}
+			next.pi.Sched = &ReplayScheduler{choices, 0}
+			next.schedule = append(s.schedule[:len(s.schedule):len(s.schedule)], choices...)
+		} else {
+			next.pi.Sched = &ReplayScheduler{}
+		}
+		if deliver {
+			in := strings.NewReader(stdin[s.stdin:])
+			out := bytes.NewBuffer(s.stdout[:len(s.stdout):len(s.stdout)])
+			next.pi.DeliverMessages(in, out)
+			next.stdin = len(stdin) - in.Len()
+			next.stdout = out.Bytes()
+		} else {
+			next.pi.RunNextNode()
+		}
+		return next
+	}
+
+	start := &checkState{p.Start(), 0, nil, nil}
+	stack := []*checkState{start}
+	for len(stack) > 0 {
+		if result.States >= maxStates {
+			result.Complete = false
+			break
+		}
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		// Run until the next decision.
+		for s != nil {
+			pi := s.pi
+			if pi.Cycle >= maxCycles || result.States >= maxStates {
+				result.Complete = false
+				break
+			}
+			if len(pi.Queue) > 0 {
+				options := len(nextOptions(pi.Queue))
+				if options == 1 {
+					s = step(s, nil, false)
+					continue
+				}
+				if visit(s) {
+					for i := options - 1; i >= 0; i-- {
+						stack = append(stack, step(s, []int{i}, false))
+					}
+				}
+				break
+			}
+			if len(pi.Ether) == 0 {
+				if out := string(s.stdout); out != result.Output {
+					result.Schedule = p.minimizeSchedule(stdin, s.schedule, result.Output, maxCycles)
+					result.Counter, _ = p.RunSchedule(stdin, result.Schedule, maxCycles)
+					return result, nil
+				}
+				break
+			}
+			if !visit(s) {
+				break
+			}
+			writes := len(writeOptions(pi.Ether))
+			if writes < 2 {
+				s = step(s, nil, true)
+				continue
+			}
+			choices := permutationChoices(writes)
+			for i := len(choices) - 1; i >= 0; i-- {
+				stack = append(stack, step(s, choices[i], true))
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// Return the choices for all orders of n writes (see ReplayScheduler).
+func permutationChoices(n int) [][]int {
+	if n < 2 {
+		return [][]int{nil}
+	}
+	choices := make([][]int, 0)
+	for i := 0; i < n; i++ {
+		for _, rest := range permutationChoices(n - 1) {
+			choices = append(choices, append([]int{i}, rest...))
+		}
+	}
+	return choices
+}
+
+// Reset choices of a schedule to 0 as long as the output differs from want.
+func (p *Program) minimizeSchedule(stdin string, schedule []int, want string,
+	maxCycles uint64) []int {
+	schedule = append(schedule[:0:0], schedule...)
+	for i := range schedule {
+		if schedule[i] == 0 {
+			continue
+		}
+		c := schedule[i]
+		schedule[i] = 0
+		if out, ok := p.RunSchedule(stdin, schedule, maxCycles); !ok || out == want {
+			schedule[i] = c
+		}
+	}
+	for len(schedule) > 0 && schedule[len(schedule)-1] == 0 {
+		schedule = schedule[:len(schedule)-1]
+	}
+	return schedule
+}
+
+// Return a key of a state that does not depend on the identity of channels or
+// the order of the queue (as far as possible) and that ignores listeners which
+// cannot receive messages anymore.
+func stateKey(s *checkState, procs map[*Proc]int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %q\n", s.stdin, s.stdout)
+	ids := make(map[*Channel]int)
+	work := make([]*Channel, 0)
+	id := func(c *Channel) int {
+		if c.IOIndex != -1 {
+			return c.IOIndex
+		} else if i, ok := ids[c]; ok {
+			return i
+		}
+		ids[c] = ioChannelOffset + len(ids)
+		work = append(work, c)
+		return ids[c]
+	}
+	// Write nodes in an order that does not depend on the channels.
+	nodes := func(prefix string, nodes []Node) {
+		keys := make([]string, len(nodes))
+		order := make([]int, len(nodes))
+		for i, n := range nodes {
+			order[i] = i
+			keys[i] = fmt.Sprint(procs[n.Proc], len(n.Refs))
+		}
+		sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+		for _, i := range order {
+			n := nodes[i]
+			// IO channels at their original index are written as a count.
+			io := 0
+			for io < len(n.Refs) && n.Refs[io].IOIndex == io {
+				io++
+			}
+			fmt.Fprintf(&b, "%v%v %v", prefix, procs[n.Proc], io)
+			for _, c := range n.Refs[io:] {
+				fmt.Fprintf(&b, " %v", id(c))
+			}
+			b.WriteString("\n")
+		}
+	}
+	nodes("q", s.pi.Queue)
+
+	// Write messages grouped by channel.
+	ether := make([][2]int, len(s.pi.Ether))
+	for i, m := range s.pi.Ether {
+		ether[i] = [2]int{id(m.Channel), id(m.Content)}
+	}
+	sort.SliceStable(ether, func(i, j int) bool { return ether[i][0] < ether[j][0] })
+	for _, m := range ether {
+		fmt.Fprintf(&b, "e%v %v\n", m[0], m[1])
+	}
+
+	// Write the listeners of IO channels and reachable channels.
+	for _, c := range s.pi.Stdio {
+		if len(c.Listeners) > 0 {
+			fmt.Fprintf(&b, "c%v\n", c.IOIndex)
+			nodes("l", c.Listeners)
+		}
+	}
+	for i := 0; i < len(work); i++ {
+		if c := work[i]; len(c.Listeners) > 0 {
+			fmt.Fprintf(&b, "c%v\n", ids[c])
+			nodes("l", c.Listeners)
+		}
+	}
+	return b.String()
+}
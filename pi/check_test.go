@@ -0,0 +1,105 @@
+package pi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Compile a program from source.
+func compileSource(t *testing.T, source string) *Program {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.pi")
+	if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// Records events without the identity of channels.
+type eventRecorder []string
+
+func (r *eventRecorder) Trace(e Event) {
+	*r = append(*r, fmt.Sprint(e.Type, e.Cycle, e.Proc, e.Listeners, e.Byte))
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		stdin   string
+		output  string
+		counter string // Output of the counterexample (empty if there is none)
+	}{
+		{"sequential", "<>stdout__A; <>stdout__B.", "", "AB", ""},
+		{"write race", "<>stdout__A. <>stdout__B.", "", "AB", "BA"},
+		{"send race", "+c;(stdout__A->c. stdout__B->c. x<-c;<>x.)", "", "A", "B"},
+		{"echo", "x<<stdin_EOF;<>stdout__E. y<<stdin__a;<>stdout__a;<>stdin_read. <>stdin_read.",
+			"aa", "aaE", ""},
+	}
+	for _, test := range tests {
+		p := compileSource(t, test.source)
+		result, err := p.Check(test.stdin, 10000, 1000)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !result.Complete {
+			t.Errorf("%v: search is incomplete after %v states", test.name, result.States)
+		}
+		if result.Output != test.output || result.Counter != test.counter {
+			t.Errorf("%v: output %q and %q, want %q and %q",
+				test.name, result.Output, result.Counter, test.output, test.counter)
+		}
+		if (result.Schedule != nil) != (len(test.counter) > 0) {
+			t.Errorf("%v: schedule %v", test.name, result.Schedule)
+		}
+	}
+}
+
+// A counterexample replays to the same output and trace every time.
+func TestCheckReplay(t *testing.T) {
+	p := compileSource(t, "+c;(stdout__A->c. stdout__B->c. x<-c;<>x. <>stdout__C. <>stdout__D.)")
+	result, err := p.Check("", 10000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Schedule == nil {
+		t.Fatal("no counterexample")
+	}
+	traces := make([]eventRecorder, 2)
+	for i := range traces {
+		p.Trace = &traces[i]
+		if out, ok := p.RunSchedule("", result.Schedule, 1000); !ok || out != result.Counter {
+			t.Fatalf("replay %v of %v gives %q, want %q", i, FormatSchedule(result.Schedule), out, result.Counter)
+		}
+	}
+	if fmt.Sprint(traces[0]) != fmt.Sprint(traces[1]) {
+		t.Errorf("replays of %v have different traces", FormatSchedule(result.Schedule))
+	}
+	if choices, err := ParseSchedule(FormatSchedule(result.Schedule)); err != nil ||
+		fmt.Sprint(choices) != fmt.Sprint(result.Schedule) {
+		t.Errorf("ParseSchedule(FormatSchedule(%v)) = %v, %v", result.Schedule, choices, err)
+	}
+}
+
+// The example programs do not depend on the schedule.
+func TestCheckExamples(t *testing.T) {
+	p, err := compileExample("bool_demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.Check("TFTOFTFO", 100000, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Complete || result.Schedule != nil || result.Output != "TF\n" {
+		t.Errorf("check bool_demo.pi: output %q, schedule %v, complete %v",
+			result.Output, result.Schedule, result.Complete)
+	}
+}
@@ -0,0 +1,212 @@
+package pi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// The core format is the output of ProcString. It is the core language with an
+// additional command to dereference a variable:
+//
+//   P,Q ::= +x;P | ~x;P | y<-x;P | y<<x;P | y->x;P | y->x. | (P Q ...)
+//
+// A program in this format is not rewritten or optimized when it is loaded,
+// hence the dereference commands inserted by Optimize are preserved. Names are
+// resolved to the innermost binding; IO channels may be written using aliases.
+// The optimizer may dereference a variable that is shadowed by a more recent
+// binding with the same name. Such a reference is written as x^n where n is the
+// number of more recent bindings of x.
+
+// LoadCore reads a program in the core format.
+func LoadCore(path string) (*Program, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := ParseCore(string(bytes), path)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{Files: []string{path}, Proc: proc}, nil
+}
+
+// ParseCore parses a program in the core format.
+func ParseCore(source string, path string) ([]*Proc, error) {
+	names := make([]string, ioChannelOffset)
+	for i := range names {
+		names[i] = IOChannelName(i)
+	}
+	errs := ErrorList([]error{})
+	p := newParser(tokenizeCore(source, Loc{path, 1, 1}), &errs)
+	proc := make([]*Proc, 0)
+	for p.peek().Kind != TokenEOF {
+		if t := p.peek(); t.Kind == TokenParClose {
+			errs.Add(diagnostic(t.Span, CodeUnexpected, "unexpected %v", t))
+			p.next()
+			continue
+		}
+		proc = append(proc, parseCore(p, names)...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return proc, nil
+}
+
+// Parse a single process (a command or a block).
+func parseCore(p *parser, names []string) []*Proc {
+	start := p.peek()
+	if start.Kind == TokenParOpen {
+		p.next()
+		proc := make([]*Proc, 0)
+		for k := p.peek().Kind; k != TokenParClose && k != TokenEOF; k = p.peek().Kind {
+			proc = append(proc, parseCore(p, names)...)
+		}
+		if end := p.next(); end.Kind != TokenParClose {
+			p.err.Add(diagnostic(end.Span, CodeUnclosed, "missing closing parenthesis").
+				Relate(start.Span, "unclosed parenthesis"))
+		}
+		return proc
+	}
+
+	// Parse command.
+	proc, pNames := parseCoreCommand(p, names)
+	if proc == nil {
+		p.skip()
+		return nil
+	}
+	switch t := p.peek(); t.Kind {
+	case TokenSemicolon:
+		p.next()
+		proc.Children = parseCore(p, pNames)
+	case TokenPeriod:
+		p.next()
+	default:
+		p.err.Add(diagnostic(t.Span, CodeTerminator, "expected semicolon or period, found %v", t))
+	}
+	return []*Proc{proc}
+}
+
+// Parse a command and return the names in scope of its children. Returns nil
+// if the command has errors.
+func parseCoreCommand(p *parser, names []string) (*Proc, []string) {
+	loc := p.peek().Start
+	errs := len(*p.err)
+	find := func(name string, shadows int) int {
+		for i := len(names) - 1; i >= 0; i-- {
+			if names[i] == name {
+				if shadows == 0 {
+					return i
+				}
+				shadows--
+			}
+		}
+		return -1
+	}
+	lookup := func(ref coreRef) int {
+		if i := find(ref.Name, ref.Shadows); i != -1 {
+			return i
+		}
+		// Use the canonical IO channel name for aliases that are not bound.
+		if index, ioErr := resolveName(ref.Name, nil); ioErr == nil {
+			if i := find(IOChannelName(index), ref.Shadows); i != -1 {
+				return i
+			}
+		}
+		// Dereferences may remove IO channels, so the bound names are not
+		// at a fixed offset. IO names are always suggested.
+		bound := make(map[string]int, len(names))
+		for i, name := range names {
+			if _, ioErr := resolveName(name, nil); ioErr != nil {
+				bound[name] = i
+			}
+		}
+		diag := diagnostic(ref.Span, CodeUnbound, "unbound variable %v", ref)
+		diag.Suggestions = suggestNames(ref.Name, bound)
+		p.err.Add(diag)
+		return 0
+	}
+	bind := func(name string) []string {
+		return append(append(names[:0:0], names...), name)
+	}
+
+	var proc *Proc
+	var pNames []string
+	switch p.peek().Kind {
+	case TokenNew:
+		p.next()
+		if v := parseCoreRef(p, false); len(v.Name) > 0 {
+			proc, pNames = &Proc{loc, PINewRef, len(names), -1, nil, v.Name}, bind(v.Name)
+		}
+	case TokenDeref:
+		p.next()
+		if v := parseCoreRef(p, true); len(v.Name) > 0 {
+			i := lookup(v)
+			pNames = append(names[:0:0], names...)
+			proc, pNames = &Proc{loc, PIDeref, i, -1, nil, names[i]}, append(pNames[:i], pNames[i+1:]...)
+		}
+	default:
+		y := parseCoreRef(p, true)
+		if len(y.Name) == 0 {
+			break
+		}
+		switch op := p.next(); op.Kind {
+		case TokenReceive, TokenReceiveAll:
+			command := PISubsOne
+			if op.Kind == TokenReceiveAll {
+				command = PISubsAll
+			}
+			if y.Shadows > 0 {
+				p.err.Add(diagnostic(y.Span, CodeName, "cannot bind %v", y))
+			} else if x := parseCoreRef(p, true); len(x.Name) > 0 {
+				proc, pNames = &Proc{loc, command, lookup(x), len(names), nil, y.Name}, bind(y.Name)
+			}
+		case TokenSend:
+			if x := parseCoreRef(p, true); len(x.Name) > 0 {
+				proc, pNames = &Proc{loc, PISend, lookup(x), lookup(y), nil, ""}, names
+			}
+		default:
+			p.err.Add(diagnostic(op.Span, CodeUnexpected, "unexpected %v", op))
+		}
+	}
+	if len(*p.err) > errs {
+		return nil, nil
+	}
+	return proc, pNames
+}
+
+// A reference in the core format (x or x^n).
+type coreRef struct {
+	Ident
+	Shadows int
+}
+
+func (r coreRef) String() string {
+	if r.Shadows > 0 {
+		return fmt.Sprintf("%v^%v", r.Name, r.Shadows)
+	}
+	return r.Name
+}
+
+// Parse a reference. The name is empty if there is an error.
+func parseCoreRef(p *parser, shadow bool) coreRef {
+	t := p.next()
+	if t.Kind != TokenName {
+		p.err.Add(diagnostic(t.Span, CodeName, "expected name, found %v", t))
+		return coreRef{}
+	}
+	ref := coreRef{Ident{t.Span, t.Content}, 0}
+	if shadow && p.peek().Kind == TokenShadow {
+		p.next()
+		n := p.next()
+		shadows, err := strconv.Atoi(n.Content)
+		if n.Kind != TokenName || err != nil {
+			p.err.Add(diagnostic(n.Span, CodeName, "expected number, found %v", n))
+			return coreRef{}
+		}
+		ref.Shadows = shadows
+		ref.End = n.End
+	}
+	return ref
+}
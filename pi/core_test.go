@@ -0,0 +1,87 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Example programs (files that are not attached by other files)
+var examplePrograms = []string{"bool_demo", "brainfuck", "calculator", "hello_world", "pi"}
+
+// Options to compile the examples
+var exampleOptions = Options{Library: os.DirFS("../examples/lib")}
+
+// Compile an example program.
+func compileExample(name string) (*Program, error) {
+	return exampleOptions.Compile("../examples/" + name + ".pi")
+}
+
+func TestCoreRoundTrip(t *testing.T) {
+	for _, name := range examplePrograms {
+		p, err := compileExample(name)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		for _, proc := range [][]*Proc{p.Core, p.Proc} {
+			core, err := ParseCore(ProcString(proc), name+".core")
+			if err != nil {
+				t.Errorf("%v: %v", name, err)
+				continue
+			}
+			// Locations are not stored in the core format.
+			if got, want := procString(core, nil, nil), procString(proc, nil, nil); got != want {
+				t.Errorf("%v: parsed core differs from the compiled core", name)
+			}
+			if got, want := ProcString(core), ProcString(proc); got != want {
+				t.Errorf("%v: parsed core has different names", name)
+			}
+		}
+	}
+}
+
+// A name that shadows an IO channel keeps referring to the bound channel.
+func TestCoreShadowIO(t *testing.T) {
+	p := compileSource(t, "+stdout__A;(<>stdout__A. <>stdout__B.)")
+	proc, err := ParseCore(ProcString(p.Core), "test.core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []*Program{p, {Proc: proc}} {
+		var out bytes.Buffer
+		if err := q.Run(context.Background(), strings.NewReader(""), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "B" {
+			t.Errorf("core %v: output %q, want %q", ProcString(q.Proc), out.String(), "B")
+		}
+	}
+}
+
+func TestParseCoreErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		errors string // Codes, columns and suggestions of the errors
+	}{
+		{"+x;x->stdout_41.", ""},
+		{"foo->stdout_41.", "E006:1[]"},
+		{"~stdin_00;foo->stdout_41.", "E006:11[]"},
+		{"+abc;~stdin_00;abd->stdout_41.", "E006:16[abc]"},
+		{"+x;y<-x^1.", "E006:7[x]"},
+	}
+	for _, test := range tests {
+		_, err := ParseCore(test.source, "test.core")
+		strs := make([]string, 0)
+		if err != nil {
+			for _, d := range Diagnostics(err) {
+				strs = append(strs, fmt.Sprintf("%v:%v%v", d.Code, d.Span.Start.Col, d.Suggestions))
+			}
+		}
+		if got := strings.Join(strs, " "); got != test.errors {
+			t.Errorf("ParseCore(%q) errors = %v, want %v", test.source, got, test.errors)
+		}
+	}
+}
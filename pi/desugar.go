@@ -0,0 +1,178 @@
+package pi
+
+import "strings"
+
+// Desugar converts surface processes into core processes. Names are resolved
+// using bound and IO channels; new references are numbered from refOffset.
+// Variables introduced by the desugaring are not visible to source names.
+func Desugar(proc []Process, refOffset int, bound map[string]int, err *ErrorList) []*Proc {
+	result := make([]*Proc, 0, len(proc))
+	for _, p := range proc {
+		result = append(result, desugar(p, refOffset, copyStrIntMap(bound), err)...)
+	}
+	return result
+}
+
+func desugar(proc Process, refOffset int, bound map[string]int, err *ErrorList) []*Proc {
+	switch p := proc.(type) {
+	case *Block:
+		return Desugar(p.Body, refOffset, bound, err)
+	case *Action:
+		d := &desugarer{refOffset, bound, err, nil, nil}
+		d.command(p.Command)
+		if p.Next != nil {
+			d.last.Children = desugar(p.Next, d.refOffset, d.bound, err)
+		}
+		return d.first
+	}
+	return nil
+}
+
+// A desugarer builds a chain of core commands.
+type desugarer struct {
+	refOffset int
+	bound     map[string]int
+	err       *ErrorList
+	first     []*Proc
+	last      *Proc
+}
+
+// Append a command to the chain.
+func (d *desugarer) add(p *Proc) {
+	if d.last == nil {
+		d.first = []*Proc{p}
+	} else {
+		d.last.Children = []*Proc{p}
+	}
+	d.last = p
+}
+
+// Append a command that binds a new reference and return its index. Source
+// names are bound in the following commands.
+func (d *desugarer) bind(loc Loc, command uint8, channel int, name string, source bool) int {
+	ref := d.refOffset
+	d.refOffset++
+	if command == PINewRef {
+		d.add(&Proc{loc, command, ref, -1, nil, name})
+	} else {
+		d.add(&Proc{loc, command, channel, ref, nil, name})
+	}
+	if source {
+		if strings.Contains(name, sQualifier) {
+			span := Span{loc, Loc{loc.Path, loc.Ln, loc.Col + len(name)}}
+			d.err.Add(diagnostic(span, CodeName, "cannot bind qualified name %v", name))
+		}
+		d.bound[name] = ref
+	}
+	return ref
+}
+
+// Append a send command.
+func (d *desugarer) send(loc Loc, message int, channel int) {
+	d.add(&Proc{loc, PISend, channel, message, nil, ""})
+}
+
+// Resolve a source name.
+func (d *desugarer) resolve(v Ident) int {
+	index, err := resolveName(v.Name, d.bound)
+	if err != nil {
+		diag := diagnostic(v.Span, CodeUnbound, "unbound variable %v", v.Name)
+		diag.Suggestions = suggestNames(v.Name, d.bound)
+		d.err.Add(diag)
+	}
+	return index
+}
+
+// Receive into the given names. Omitted names receive into a fresh variable.
+func (d *desugarer) receive(names []Ident, command uint8, channel func() int, fresh string) {
+	for _, v := range names {
+		if len(v.Name) == 0 {
+			d.bind(v.Start, command, channel(), fresh, false)
+		} else {
+			d.bind(v.Start, command, channel(), v.Name, true)
+		}
+	}
+}
+
+// Send the given names to channel. Omitted names send a fresh trigger.
+func (d *desugarer) sendAll(names []Ident, channel func() int) {
+	for _, v := range names {
+		if len(v.Name) == 0 {
+			d.send(v.Start, d.bind(v.Start, PINewRef, -1, "@3", false), channel())
+		} else {
+			d.send(v.Start, d.resolve(v), channel())
+		}
+	}
+}
+
+// Append the core commands of a command. The names of fresh variables (@n) are
+// only used to print the core program.
+func (d *desugarer) command(c Command) {
+	loc := c.Start
+	ref := func(index int) func() int {
+		return func() int { return index }
+	}
+	target := func() int { return d.resolve(c.Targets[0]) }
+
+	switch c.Form {
+	case FormCreate, FormCreateSend:
+		// +a,b->x,y === +a;+b;a,b->x,y
+		for _, v := range c.Args {
+			d.bind(v.Start, PINewRef, -1, v.Name, true)
+		}
+		if c.Form == FormCreateSend {
+			d.sendTargets(c.Args, c.Targets)
+		}
+	case FormReceive:
+		// a,b<-x === a<-x;b<-x and <-x === @1<-x
+		d.receive(c.Args, PISubsOne, target, "@1")
+	case FormReceiveAll:
+		// <<x === @2<<x
+		d.receive(c.Args, PISubsAll, target, "@2")
+	case FormSend:
+		// a,b->x,y === a->x;a->y;b->x;b->y and ->x === +@3;@3->x
+		d.sendTargets(c.Args, c.Targets)
+	case FormTrigger:
+		// <>x === +@4;@4->x;<-@4
+		t := d.bind(loc, PINewRef, -1, "@4", false)
+		d.send(loc, t, target())
+		d.bind(loc, PISubsOne, t, "@1", false)
+	case FormForward:
+		// x>>a,b === @5<<x;@5->a,b
+		m := d.bind(loc, PISubsAll, d.resolve(c.Args[0]), "@5", false)
+		for _, v := range c.Targets {
+			d.send(v.Start, m, d.resolve(v))
+		}
+	case FormTunnelSend:
+		// y>->x === +@6a;@6a->x;@6b<-@6a;y->@6b
+		a := d.bind(loc, PINewRef, -1, "@6a", false)
+		d.send(loc, a, target())
+		b := d.bind(loc, PISubsOne, a, "@6b", false)
+		d.sendAll(c.Args, ref(b))
+	case FormTunnelReceive:
+		// y<-<x === +@7;@7->x;y<-@7
+		t := d.bind(loc, PINewRef, -1, "@7", false)
+		d.send(loc, t, target())
+		d.receive(c.Args, PISubsOne, ref(t), "@1")
+	case FormTunnelOne, FormTunnelAll:
+		// y<<-x === @8a<-x;+@8b->@8a;y<-@8b
+		// y<<<x === @9a<<x;+@9b->@9a;y<-@9b
+		command, a, b := uint8(PISubsOne), "@8a", "@8b"
+		if c.Form == FormTunnelAll {
+			command, a, b = PISubsAll, "@9a", "@9b"
+		}
+		ra := d.bind(loc, command, target(), a, false)
+		rb := d.bind(loc, PINewRef, -1, b, false)
+		d.send(loc, rb, ra)
+		d.receive(c.Args, PISubsOne, ref(rb), "@1")
+	}
+}
+
+// Send every name to every target.
+func (d *desugarer) sendTargets(names []Ident, targets []Ident) {
+	for _, v := range names {
+		for _, t := range targets {
+			d.sendAll([]Ident{v}, func() int { return d.resolve(t) })
+		}
+	}
+}
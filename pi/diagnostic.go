@@ -0,0 +1,283 @@
+package pi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Severity of a diagnostic
+type Severity uint8
+
+// Severities
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return "error"
+}
+
+// Diagnostic codes
+const (
+	CodeUnexpected = "E001" // Unexpected token
+	CodeUnclosed   = "E002" // Missing closing parenthesis
+	CodeTerminator = "E003" // Missing semicolon or period
+	CodeName       = "E004" // Missing or invalid name
+	CodeNameCount  = "E005" // Wrong number of names for a command
+	CodeUnbound    = "E006" // Unbound variable
+	CodeDirective  = "E007" // Invalid directive
+	CodeConflict   = "E008" // Conflicting declarations
+	CodeAttach     = "E009" // Missing attached file
+	CodeMacro      = "E010" // Invalid macro definition or use
+)
+
+// Diagnostic is a message about a span of source code. It may have secondary
+// spans that point at related source code and suggestions to fix the problem.
+type Diagnostic struct {
+	Severity    Severity
+	Code        string
+	Message     string
+	Span        Span
+	Related     []Related
+	Suggestions []string
+}
+
+// Related is a secondary span of a diagnostic.
+type Related struct {
+	Span    Span
+	Message string
+}
+
+// Create an error diagnostic.
+func diagnostic(span Span, code string, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{SeverityError, code, fmt.Sprintf(format, a...), span, nil, nil}
+}
+
+// Relate adds a secondary span.
+func (d *Diagnostic) Relate(span Span, format string, a ...interface{}) *Diagnostic {
+	d.Related = append(d.Related, Related{span, fmt.Sprintf(format, a...)})
+	return d
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%v; %v", d.Span.Start, d.Message)
+}
+
+// Diagnostics returns the diagnostics in an error sorted by location (files are
+// kept in order of appearance). Errors that are not diagnostics are converted
+// to diagnostics without a location.
+func Diagnostics(err error) []*Diagnostic {
+	errs, ok := err.(ErrorList)
+	if !ok {
+		errs = ErrorList{err}
+	}
+	diags := make([]*Diagnostic, len(errs))
+	for i, err := range errs {
+		if d, ok := err.(*Diagnostic); ok {
+			diags[i] = d
+		} else {
+			diags[i] = &Diagnostic{Message: err.Error()}
+		}
+	}
+	files := make(map[string]int)
+	for _, d := range diags {
+		if _, ok := files[d.Span.Start.Path]; !ok {
+			files[d.Span.Start.Path] = len(files)
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i].Span.Start, diags[j].Span.Start
+		if a.Path != b.Path {
+			return files[a.Path] < files[b.Path]
+		}
+		return a.Ln < b.Ln || a.Ln == b.Ln && a.Col < b.Col
+	})
+	return diags
+}
+
+// WriteDiagnostics writes the diagnostics in err for a human reader. The source
+// line of every span (read using read) is printed with the span underlined.
+func WriteDiagnostics(w io.Writer, err error, read ReadFunc) {
+	sources := make(map[string][]string)
+	snippet := func(span Span, message string) {
+		lines, ok := sources[span.Start.Path]
+		if !ok && len(span.Start.Path) > 0 {
+			if bytes, err := read(span.Start.Path); err == nil {
+				lines = strings.Split(string(bytes), "\n")
+			}
+			sources[span.Start.Path] = lines
+		}
+		ln := span.Start.Ln
+		if ln < 1 || ln > len(lines) {
+			return
+		}
+		line := strings.TrimRight(lines[ln-1], "\r")
+		gutter := strings.Repeat(" ", len(fmt.Sprint(ln)))
+		fmt.Fprintf(w, "%v |\n%v | %v\n", gutter, ln, line)
+		fmt.Fprintf(w, "%v | %v\n", gutter, strings.TrimRight(underline(line, span)+" "+message, " "))
+	}
+	for _, d := range Diagnostics(err) {
+		if len(d.Code) > 0 {
+			fmt.Fprintf(w, "%v[%v]: %v\n", d.Severity, d.Code, d.Message)
+		} else {
+			fmt.Fprintf(w, "%v: %v\n", d.Severity, d.Message)
+		}
+		if len(d.Span.Start.Path) > 0 {
+			fmt.Fprintf(w, "  --> %v\n", d.Span.Start)
+			snippet(d.Span, "")
+		}
+		for _, r := range d.Related {
+			fmt.Fprintf(w, "  --> %v\n", r.Span.Start)
+			snippet(r.Span, r.Message)
+		}
+		if len(d.Suggestions) > 0 {
+			fmt.Fprintf(w, "  = help: did you mean %v?\n", strings.Join(quote(d.Suggestions), " or "))
+		}
+	}
+}
+
+// Underline the span in the given line (tabs are kept for alignment).
+func underline(line string, span Span) string {
+	start, end := span.Start.Col-1, span.End.Col-1
+	if span.End.Ln != span.Start.Ln {
+		end = len(line)
+	}
+	if start > len(line) {
+		start = len(line)
+	}
+	if end <= start {
+		end = start + 1
+	}
+	prefix := []byte(line[:start])
+	for i, c := range prefix {
+		if c != '\t' {
+			prefix[i] = ' '
+		}
+	}
+	return string(prefix) + strings.Repeat("^", end-start)
+}
+
+func quote(strs []string) []string {
+	quoted := make([]string, len(strs))
+	for i, s := range strs {
+		quoted[i] = fmt.Sprintf("\"%v\"", s)
+	}
+	return quoted
+}
+
+type jsonSpan struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	EndLine int    `json:"end_line"`
+	EndCol  int    `json:"end_col"`
+	Message string `json:"message,omitempty"`
+}
+
+type jsonDiagnostic struct {
+	Severity    string     `json:"severity"`
+	Code        string     `json:"code,omitempty"`
+	Message     string     `json:"message"`
+	Span        *jsonSpan  `json:"span,omitempty"`
+	Related     []jsonSpan `json:"related,omitempty"`
+	Suggestions []string   `json:"suggestions,omitempty"`
+}
+
+// WriteDiagnosticsJSON writes the diagnostics in err as JSON lines. Files are
+// written as absolute paths.
+func WriteDiagnosticsJSON(w io.Writer, err error) error {
+	toJSON := func(span Span, message string) jsonSpan {
+		path, _ := filepath.Abs(span.Start.Path)
+		return jsonSpan{path, span.Start.Ln, span.Start.Col, span.End.Ln, span.End.Col, message}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, d := range Diagnostics(err) {
+		jd := jsonDiagnostic{d.Severity.String(), d.Code, d.Message, nil, nil, d.Suggestions}
+		if len(d.Span.Start.Path) > 0 {
+			span := toJSON(d.Span, "")
+			jd.Span = &span
+		}
+		for _, r := range d.Related {
+			jd.Related = append(jd.Related, toJSON(r.Span, r.Message))
+		}
+		if err := enc.Encode(jd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Suggest names that are similar to name. Candidates are the bound names and the
+// IO channels.
+func suggestNames(name string, bound map[string]int) []string {
+	candidates := make([]string, 0, len(bound)+ioChannelOffset)
+	for k := range bound {
+		candidates = append(candidates, k)
+	}
+	for i := 0; i < ioChannelOffset; i++ {
+		candidates = append(candidates, IOChannelName(i))
+	}
+	for c := '0'; c <= 'z'; c++ {
+		if isNameChar(byte(c)) && c != '_' {
+			candidates = append(candidates, "stdin__"+string(c), "stdout__"+string(c))
+		}
+	}
+
+	// Find candidates with the smallest edit distance.
+	maxDistance := 1 + len(name)/4
+	names := make([]string, 0)
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < maxDistance {
+			maxDistance = d
+			names = []string{c}
+		} else if d == maxDistance {
+			names = append(names, c)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 3 {
+		names = names[:3]
+	}
+	return names
+}
+
+// Levenshtein distance
+func editDistance(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			next := minInt(minInt(row[j]+1, row[j-1]+1), prev+cost)
+			prev, row[j] = row[j], next
+		}
+	}
+	return row[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,144 @@
+package pi
+
+import "strings"
+
+// Format returns the source code of a PI file in the canonical layout. Line
+// breaks, comments and directives are kept (with at most one empty line in a
+// row). Commands are written without spaces, commands in a chain and parallel
+// processes are separated by a single space, lines are indented by two spaces
+// for every enclosing block and trailing comments of consecutive lines are
+// aligned. Source code with syntax errors is not formatted.
+func Format(source string, path string) (string, error) {
+	directives, offset, code := ParseDirectives(source, path)
+	errs := ErrorList([]error{})
+	tokens := Tokenize(code, Loc{path, offset + 1, 1})
+	macros := parseSourceFile(Options{}, path, path, directives, &errs).macros
+	Parse(ExpandMacros(tokens, macros, &errs), &errs)
+	if len(errs) > 0 {
+		return "", errs
+	}
+
+	// Format the lines before the code.
+	lines := make([]string, 0)
+	sourceLines := strings.Split(source, "\n")
+	for i, line := range sourceLines[:offset] {
+		line = strings.TrimSpace(line)
+		for _, d := range directives {
+			if d.Start.Ln == i+1 {
+				line = "#" + d.Key + ": " + d.Value
+				if c := strings.Index(sourceLines[i][d.End.Col-1:], sComment); c != -1 {
+					line += " " + strings.TrimSpace(sourceLines[i][d.End.Col-1+c:])
+				}
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	// Group tokens by line.
+	lineTokens := make([][]Token, len(sourceLines)-offset)
+	for _, t := range tokens {
+		i := t.Start.Ln - offset - 1
+		lineTokens[i] = append(lineTokens[i], t)
+	}
+
+	// Format the code lines. Every open block stores the indentation of the
+	// line in which it was opened and the indentation of its contents. Blocks
+	// that start with a process on the same line as the parenthesis are not
+	// indented. Lines that continue a chain are indented once more if they were
+	// indented more than the line that started the chain.
+	blocks := make([][2]int, 0)
+	comments := make([]string, len(lines)) // Trailing comments
+	last := TokenInvalid                   // Last token that is not a comment
+	chainStart := 0                        // Source indentation of the chain
+	for _, line := range lineTokens {
+		if len(line) == 0 {
+			lines = append(lines, "")
+			comments = append(comments, "")
+			continue
+		}
+		indent := 0
+		if len(blocks) > 0 {
+			indent = blocks[len(blocks)-1][1]
+		}
+		if line[0].Kind == TokenParClose {
+			indent = blocks[len(blocks)-1][0]
+		} else if line[0].Kind != TokenComment {
+			if last != TokenSemicolon {
+				chainStart = line[0].Start.Col
+			} else if line[0].Start.Col > chainStart {
+				indent++
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString(strings.Repeat("  ", indent))
+		comment := ""
+		for i, t := range line {
+			switch t.Kind {
+			case TokenComment:
+				comment = strings.TrimRight(t.Content, " \t\r")
+				continue
+			case TokenParOpen:
+				inner := indent + 1
+				if i+1 < len(line) && line[i+1].Kind != TokenComment {
+					inner = indent
+				}
+				blocks = append(blocks, [2]int{indent, inner})
+			case TokenParClose:
+				blocks = blocks[:len(blocks)-1]
+			}
+			if i > 0 {
+				b.WriteString(tokenSpace(line[i-1].Kind, t.Kind))
+			}
+			b.WriteString(t.Content)
+			last = t.Kind
+		}
+		if b.Len() == 2*indent {
+			// Comment line
+			b.WriteString(comment)
+			comment = ""
+		}
+		lines = append(lines, b.String())
+		comments = append(comments, comment)
+	}
+
+	// Align trailing comments of consecutive lines.
+	for i := 0; i < len(lines); {
+		j, width := i, 0
+		for ; j < len(lines) && len(comments[j]) > 0; j++ {
+			if len(lines[j]) > width {
+				width = len(lines[j])
+			}
+		}
+		for ; i < j; i++ {
+			lines[i] += strings.Repeat(" ", width-len(lines[i])+1) + comments[i]
+		}
+		i = j + 1
+	}
+
+	// Remove repeated empty lines and empty lines at the start and the end.
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		n := len(result)
+		if len(line) > 0 || n > 0 && len(result[n-1]) > 0 {
+			result = append(result, line)
+		}
+	}
+	for len(result) > 0 && len(result[len(result)-1]) == 0 {
+		result = result[:len(result)-1]
+	}
+	return strings.Join(result, "\n") + "\n", nil
+}
+
+// Return the space between two tokens on the same line.
+func tokenSpace(prev TokenKind, next TokenKind) string {
+	switch {
+	case prev == TokenParOpen, next == TokenParClose, next == TokenSemicolon, next == TokenPeriod:
+		return ""
+	case prev == TokenSemicolon && next == TokenParOpen:
+		return ""
+	case prev == TokenSemicolon, prev == TokenPeriod, prev == TokenParClose:
+		return " "
+	}
+	return ""
+}
@@ -0,0 +1,342 @@
+package pi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// GoPi is an alternative runtime that executes every node in its own goroutine.
+// Listeners are goroutines that block on a Go channel until the central
+// registry wakes them up with a message. Messages are still delivered in cycles
+// (up to one message per channel per cycle) so that the semantics are the same
+// as those of Pi. A cycle ends when all node goroutines are blocked or done.
+type GoPi struct {
+	Cycle uint64
+	Stdio []*GoChannel
+
+	mu       sync.Mutex
+	idle     *sync.Cond
+	active   int64               // Number of running node goroutines
+	ether    []GoMessage         // Messages waiting for delivery
+	registry map[*GoChannel]bool // Channels that have listeners
+}
+
+// GoChannel holds the registry information of a single channel. The number of
+// references is used to determine when a channel is dead; that is when it is
+// only referenced by its own listeners so nobody can send to it anymore.
+type GoChannel struct {
+	IOIndex   int          // -1 or IO channel index
+	Listeners []GoListener // Current channel listeners
+	PrevCycle uint64       // Previous cycle in which a message was delivered
+
+	refs     int64 // Number of references held by nodes and messages
+	selfRefs int64 // Number of references held by listeners on this channel
+}
+
+// GoListener is a node that waits for messages on a channel.
+type GoListener struct {
+	Proc *Proc
+	Wake chan *GoChannel // Receives message content, closed when channel is dead
+	Refs int64           // Number of references to the listened channel
+}
+
+// GoMessage represents a single message.
+type GoMessage struct {
+	Channel *GoChannel
+	Content *GoChannel
+}
+
+// Initialize sets up the initial program state and starts the main node.
+func (pi *GoPi) Initialize(proc []*Proc) {
+	pi.idle = sync.NewCond(&pi.mu)
+	pi.registry = make(map[*GoChannel]bool)
+
+	// Create IO channels. These are never dead.
+	pi.Stdio = make([]*GoChannel, ioChannelOffset)
+	for i := 0; i < ioChannelOffset; i++ {
+		pi.Stdio[i] = &GoChannel{IOIndex: i, refs: 1}
+	}
+	refs := append(pi.Stdio[:0:0], pi.Stdio...)
+	acquireAll(refs)
+	pi.spawn(proc, refs)
+}
+
+// Run executes the program until all messages are delivered and all remaining
+// listeners are waiting on channels that are dead or are IO channels, or until
+// the context is done.
+func (pi *GoPi) Run(ctx context.Context, input io.Reader, output io.Writer) error {
+	err := ctx.Err()
+	for err == nil {
+		pi.mu.Lock()
+		for atomic.LoadInt64(&pi.active) > 0 {
+			pi.idle.Wait()
+		}
+		collected := pi.collect()
+		empty := len(pi.ether) == 0
+		if !empty {
+			pi.deliver(input, output)
+		}
+		pi.mu.Unlock()
+		if empty && !collected {
+			break
+		}
+		err = ctx.Err()
+	}
+
+	// Release the remaining listeners such that their goroutines exit.
+	pi.mu.Lock()
+	for atomic.LoadInt64(&pi.active) > 0 {
+		pi.idle.Wait()
+	}
+	for c := range pi.registry {
+		pi.close(c)
+	}
+	for atomic.LoadInt64(&pi.active) > 0 {
+		pi.idle.Wait()
+	}
+	pi.mu.Unlock()
+	return err
+}
+
+// spawn starts a goroutine for each process. The first process takes ownership
+// of refs, the others receive a copy.
+func (pi *GoPi) spawn(proc []*Proc, refs []*GoChannel) {
+	atomic.AddInt64(&pi.active, int64(len(proc)))
+	for i, p := range proc {
+		if i == 0 {
+			go pi.run(p, refs)
+		} else {
+			pRefs := append(refs[:0:0], refs...)
+			acquireAll(pRefs)
+			go pi.run(p, pRefs)
+		}
+	}
+}
+
+// done marks a node goroutine as blocked or finished.
+func (pi *GoPi) done() {
+	if atomic.AddInt64(&pi.active, -1) == 0 {
+		pi.mu.Lock()
+		pi.idle.Signal()
+		pi.mu.Unlock()
+	}
+}
+
+// run executes a node until it finishes or becomes a listener.
+func (pi *GoPi) run(p *Proc, refs []*GoChannel) {
+	for {
+		switch p.Command {
+		case PINewRef:
+			assert(len(refs) == p.Channel)
+			refs = append(refs, &GoChannel{IOIndex: -1, refs: 1})
+
+		case PIDeref:
+			release(refs[p.Channel])
+			refs = deleteGoRef(refs, p.Channel)
+
+		case PISubsOne:
+			content, ok := <-pi.listen(p, refs)
+			if !ok {
+				releaseAll(refs)
+				pi.done()
+				return
+			}
+			refs = append(refs, content)
+
+		case PISubsAll:
+			for content := range pi.listen(p, refs) {
+				cRefs := append(refs[:0:0], refs...)
+				acquireAll(cRefs)
+				pi.spawn(p.Children, append(cRefs, content))
+				pi.done()
+			}
+			releaseAll(refs)
+			pi.done()
+			return
+
+		case PISend:
+			pi.send(refs[p.Channel], refs[p.Message])
+		}
+
+		// Continue with the first child in this goroutine.
+		switch len(p.Children) {
+		case 0:
+			releaseAll(refs)
+			pi.done()
+			return
+		case 1:
+		default:
+			pRefs := append(refs[:0:0], refs...)
+			acquireAll(pRefs)
+			pi.spawn(p.Children[1:], pRefs)
+		}
+		p = p.Children[0]
+	}
+}
+
+// listen registers the node as a listener and marks it as blocked.
+func (pi *GoPi) listen(p *Proc, refs []*GoChannel) chan *GoChannel {
+	assert(len(refs) == p.Message)
+	channel := refs[p.Channel]
+	wake := make(chan *GoChannel, 1)
+	listener := GoListener{p, wake, 0}
+	for _, r := range refs {
+		if r == channel {
+			listener.Refs++
+		}
+	}
+
+	pi.mu.Lock()
+	channel.Listeners = append(channel.Listeners, listener)
+	channel.selfRefs += listener.Refs
+	pi.registry[channel] = true
+	pi.mu.Unlock()
+	pi.done()
+	return wake
+}
+
+// send puts a message into the ether.
+func (pi *GoPi) send(channel *GoChannel, content *GoChannel) {
+	acquire(channel)
+	acquire(content)
+	pi.mu.Lock()
+	pi.ether = append(pi.ether, GoMessage{channel, content})
+
+	// Messages to the debug channel are handled immediately.
+	if channel.IOIndex == miscIOChannels["DEBUG"] {
+		content.PrintDebugInfo()
+	}
+	pi.mu.Unlock()
+}
+
+// deliver delivers up to one message per channel from the ether. The caller
+// must hold the lock and all node goroutines must be blocked.
+func (pi *GoPi) deliver(input io.Reader, output io.Writer) {
+	pi.Cycle++
+	messages := pi.ether
+	pi.ether = nil
+
+	for _, m := range messages {
+		// Send only one message per channel per cycle.
+		if m.Channel.PrevCycle < pi.Cycle {
+			m.Channel.PrevCycle = pi.Cycle
+		} else {
+			pi.ether = append(pi.ether, m)
+			continue
+		}
+
+		// Wake up listeners. PISubsAll listeners keep listening.
+		listeners := m.Channel.Listeners
+		m.Channel.Listeners = nil
+		for _, l := range listeners {
+			if l.Proc.Command == PISubsAll {
+				m.Channel.Listeners = append(m.Channel.Listeners, l)
+			} else {
+				m.Channel.selfRefs -= l.Refs
+			}
+			acquire(m.Content)
+			atomic.AddInt64(&pi.active, 1)
+			l.Wake <- m.Content
+		}
+		if len(m.Channel.Listeners) == 0 {
+			delete(pi.registry, m.Channel)
+		}
+
+		// Handle IO messages.
+		if m.Channel.IOIndex != -1 {
+			pi.ether = append(pi.ether, pi.handleStdio(input, output, m)...)
+		}
+		release(m.Channel)
+		release(m.Content)
+	}
+}
+
+// handleStdio is the equivalent of handleStdioMessage for this runtime.
+func (pi *GoPi) handleStdio(in io.Reader, out io.Writer, m GoMessage) []GoMessage {
+	var reply *GoChannel
+	id := m.Channel.IOIndex
+	if id == miscIOChannels["stdin_read"] {
+		buf := make([]byte, 1)
+		if _, err := in.Read(buf); err == nil {
+			reply = pi.Stdio[buf[0]]
+		} else if err == io.EOF {
+			reply = pi.Stdio[miscIOChannels["stdin_EOF"]]
+		}
+	} else if stdoutOffset <= id && id < stdoutOffset+256 {
+		out.Write([]byte{byte(id - stdoutOffset)})
+		reply = m.Content
+	}
+	if reply == nil {
+		return nil
+	}
+	acquire(reply)
+	acquire(m.Content)
+	return []GoMessage{GoMessage{reply, m.Content}}
+}
+
+// collect closes all dead channels in the registry and reports if any channel
+// was closed. The caller must hold the lock.
+func (pi *GoPi) collect() bool {
+	collected := false
+	for c := range pi.registry {
+		if c.IOIndex == -1 && atomic.LoadInt64(&c.refs) == c.selfRefs {
+			pi.close(c)
+			collected = true
+		}
+	}
+	return collected
+}
+
+// close wakes up all listeners of a channel with a closed Go channel such that
+// they release their references. The caller must hold the lock.
+func (pi *GoPi) close(c *GoChannel) {
+	for _, l := range c.Listeners {
+		atomic.AddInt64(&pi.active, 1)
+		close(l.Wake)
+	}
+	c.Listeners = nil
+	c.selfRefs = 0
+	delete(pi.registry, c)
+}
+
+// PrintDebugInfo prints the listeners.
+func (c *GoChannel) PrintDebugInfo() {
+	println()
+	println("--- DEBUG SECTION ---")
+	fmt.Printf("channel address: %p\n", c)
+	for _, l := range c.Listeners {
+		fmt.Printf("+ %v\n", l.Proc.Location)
+	}
+	println("---------------------")
+}
+
+func acquire(c *GoChannel) {
+	atomic.AddInt64(&c.refs, 1)
+}
+
+func release(c *GoChannel) {
+	atomic.AddInt64(&c.refs, -1)
+}
+
+func acquireAll(refs []*GoChannel) {
+	for _, c := range refs {
+		acquire(c)
+	}
+}
+
+func releaseAll(refs []*GoChannel) {
+	for _, c := range refs {
+		release(c)
+	}
+}
+
+func deleteGoRef(src []*GoChannel, i int) []*GoChannel {
+	if i < len(src)-1 {
+		copy(src[i:], src[i+1:])
+	}
+	src[len(src)-1] = nil
+	return src[:len(src)-1]
+}
@@ -0,0 +1,220 @@
+package pi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteTreeDOT writes the process tree in the DOT language. Every process is
+// labelled with its command (with source names from scopes) and location.
+func WriteTreeDOT(w io.Writer, proc []*Proc, scopes map[*Proc][]string) {
+	fmt.Fprintln(w, "digraph tree {")
+	fmt.Fprintln(w, "  node [shape=box fontname=monospace];")
+	fmt.Fprintln(w, "  root [label=\"program\" shape=ellipse];")
+	ids := make(map[*Proc]string)
+	var walk func(parent string, proc []*Proc)
+	walk = func(parent string, proc []*Proc) {
+		for _, p := range proc {
+			id := fmt.Sprintf("p%v", len(ids))
+			ids[p] = id
+			fmt.Fprintf(w, "  %v [label=%v];\n", id, dotQuote(procLabel(p, scopes)))
+			fmt.Fprintf(w, "  %v -> %v;\n", parent, id)
+			walk(id, p.Children)
+		}
+	}
+	walk("root", proc)
+	fmt.Fprintln(w, "}")
+}
+
+// WriteFlowDOT writes the channel flow of a program in the DOT language. Every
+// binding of a name is a node (IO channels are boxes and names that are bound
+// by a subscription are dashed); processes that send are connected to the
+// channel they send on and channels are connected to the processes that
+// subscribe to them. Edges are labelled with the message name.
+func WriteFlowDOT(w io.Writer, proc []*Proc, scopes map[*Proc][]string) {
+	fmt.Fprintln(w, "digraph flow {")
+	fmt.Fprintln(w, "  node [fontname=monospace];")
+	bindings := 0
+	procs := 0
+	// IO channels are declared when they are first used.
+	declared := MakeSet()
+	io := make(map[string]int)
+	refs := make([]string, ioChannelOffset)
+	for i := range refs {
+		refs[i] = fmt.Sprintf("io%v", i)
+		io[refs[i]] = i
+	}
+	var walk func(proc []*Proc, refs []string)
+	walk = func(proc []*Proc, refs []string) {
+		for _, p := range proc {
+			names := scopes[p]
+			name := func(i int) string {
+				if i < len(names) && len(names[i]) > 0 {
+					return names[i]
+				}
+				return fmt.Sprintf("%v_", i)
+			}
+			// Return the node of a reference.
+			ref := func(i int) string {
+				id := refs[i]
+				if index, ok := io[id]; ok && !declared.Contains(id) {
+					declared.Add(id)
+					fmt.Fprintf(w, "  %v [label=%v shape=box];\n", id, dotQuote(IOChannelName(index)))
+				}
+				return id
+			}
+			pRefs := refs
+			switch p.Command {
+			case PINewRef, PISubsOne, PISubsAll:
+				// Bind a name.
+				bound, style := p.Name, "solid"
+				if len(bound) == 0 {
+					bound = fmt.Sprintf("%v_", len(refs))
+				}
+				if p.Command != PINewRef {
+					style = "dashed"
+				}
+				binding := fmt.Sprintf("b%v", bindings)
+				bindings++
+				fmt.Fprintf(w, "  %v [label=%v style=%v];\n", binding,
+					dotQuote(fmt.Sprintf("%v\n%v", bound, p.Location)), style)
+				pRefs = append(refs[:len(refs):len(refs)], binding)
+				if p.Command != PINewRef {
+					id := fmt.Sprintf("p%v", procs)
+					procs++
+					fmt.Fprintf(w, "  %v [label=%v shape=box];\n", id, dotQuote(procLabel(p, scopes)))
+					fmt.Fprintf(w, "  %v -> %v [label=%v];\n", ref(p.Channel), id, dotQuote(bound))
+					fmt.Fprintf(w, "  %v -> %v [style=dotted arrowhead=none];\n", id, binding)
+				}
+			case PIDeref:
+				pRefs = deleteName(refs, p.Channel)
+			case PISend:
+				id := fmt.Sprintf("p%v", procs)
+				procs++
+				fmt.Fprintf(w, "  %v [label=%v shape=box];\n", id, dotQuote(procLabel(p, scopes)))
+				fmt.Fprintf(w, "  %v -> %v [label=%v];\n", id, ref(p.Channel), dotQuote(name(p.Message)))
+			}
+			walk(p.Children, pRefs)
+		}
+	}
+	walk(proc, refs)
+	fmt.Fprintln(w, "}")
+}
+
+// WriteDOT writes a snapshot of the state in the DOT language: the nodes in the
+// queue, the listeners of channels that can still receive a message, the
+// channels they use and the messages in the ether. Channels are labelled with
+// the source names under which they are known.
+func (pi *Pi) WriteDOT(w io.Writer, scopes map[*Proc][]string) {
+	fmt.Fprintln(w, "digraph state {")
+	fmt.Fprintf(w, "  label=%v;\n", dotQuote(fmt.Sprintf("cycle %v", pi.Cycle)))
+	fmt.Fprintln(w, "  node [fontname=monospace];")
+
+	// Collect channel labels.
+	ids := make(map[*Channel]int)
+	labels := make(map[*Channel][]string)
+	channel := func(c *Channel) string {
+		id, ok := ids[c]
+		if !ok {
+			id = len(ids)
+			ids[c] = id
+		}
+		return fmt.Sprintf("c%v", id)
+	}
+	label := func(c *Channel, name string) {
+		for _, l := range labels[c] {
+			if l == name {
+				return
+			}
+		}
+		labels[c] = append(labels[c], name)
+	}
+	nodeRefs := func(n Node) []int {
+		switch n.Proc.Command {
+		case PISend:
+			return []int{n.Proc.Channel, n.Proc.Message}
+		case PIDeref, PISubsOne, PISubsAll:
+			return []int{n.Proc.Channel}
+		}
+		return nil
+	}
+	nodes := 0
+	node := func(n Node, style string) string {
+		id := fmt.Sprintf("n%v", nodes)
+		nodes++
+		fmt.Fprintf(w, "  %v [label=%v shape=box style=%v];\n", id,
+			dotQuote(procLabel(n.Proc, scopes)), style)
+		for _, i := range nodeRefs(n) {
+			if i < len(n.Refs) {
+				if names := scopes[n.Proc]; i < len(names) && len(names[i]) > 0 {
+					label(n.Refs[i], names[i])
+				}
+			}
+		}
+		return id
+	}
+
+	for _, n := range pi.Queue {
+		id := node(n, "solid")
+		for _, i := range nodeRefs(n) {
+			if i < len(n.Refs) {
+				fmt.Fprintf(w, "  %v -> %v [style=dotted];\n", id, channel(n.Refs[i]))
+			}
+		}
+	}
+	// Sort the listening channels to write a deterministic graph.
+	listening := make([]*Channel, 0)
+	for _, c := range pi.reachable() {
+		if len(c.Listeners) > 0 {
+			listening = append(listening, c)
+		}
+	}
+	sort.Slice(listening, func(i, j int) bool {
+		a, b := listening[i], listening[j]
+		if a.IOIndex != b.IOIndex {
+			return a.IOIndex < b.IOIndex
+		}
+		return locLess(a.Listeners[0].Proc.Location, b.Listeners[0].Proc.Location)
+	})
+	for _, c := range listening {
+		for _, n := range c.Listeners {
+			id := node(n, "rounded")
+			fmt.Fprintf(w, "  %v -> %v;\n", channel(c), id)
+		}
+	}
+	for _, m := range pi.Ether {
+		fmt.Fprintf(w, "  %v -> %v [color=red label=\"message\"];\n",
+			channel(m.Content), channel(m.Channel))
+	}
+	channels := make([]*Channel, len(ids))
+	for c, id := range ids {
+		channels[id] = c
+	}
+	for id, c := range channels {
+		text := strings.Join(labels[c], ", ")
+		if c.IOIndex != -1 {
+			text = IOChannelName(c.IOIndex)
+		} else if len(text) == 0 {
+			text = fmt.Sprintf("#%v", id)
+		}
+		fmt.Fprintf(w, "  c%v [label=%v];\n", id, dotQuote(text))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// Return the label of a process: its command and location.
+func procLabel(p *Proc, scopes map[*Proc][]string) string {
+	return fmt.Sprintf("%v\n%v", p.CommandName(scopes[p]), p.Location)
+}
+
+// Delete a name without changing the original slice.
+func deleteName(names []string, i int) []string {
+	return append(names[:i:i], names[i+1:]...)
+}
+
+func dotQuote(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,158 @@
+package pi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Language primitives
+const (
+	sComment   = "!"
+	sParOpen   = "("
+	sParClose  = ")"
+	sSemicolon = ";"
+	sPeriod    = "."
+	sQualifier = "::"
+)
+
+var (
+	directiveRE, _ = regexp.Compile("^#([^:]+):(.*)$")
+)
+
+// Core language
+const (
+	PINewRef uint8 = 1 << iota
+	PIDeref
+	PISubsOne
+	PISubsAll
+	PISend
+)
+
+// Proc is a PI process.
+type Proc struct {
+	Location Loc
+	Command  uint8
+	Channel  int     // Variable of new or receive/send channel
+	Message  int     // Variable of receive/send message
+	Children []*Proc // Child processes (parallel)
+	Name     string  // Source name of the variable that is bound or dereferenced
+}
+
+// IO channels
+var (
+	// 0..255
+	stdinHexRE, _      = regexp.Compile("^stdin_([0-9A-F]{2})$")
+	stdinAlphaNumRE, _ = regexp.Compile("^stdin__([a-zA-Z0-9])$")
+
+	// 256..511
+	stdoutOffset        = 256
+	stdoutHexRE, _      = regexp.Compile("^stdout_([0-9A-F]{2})$")
+	stdoutAlphaNumRE, _ = regexp.Compile("^stdout__([a-zA-Z0-9])$")
+
+	// Other channels
+	miscIOChannels = map[string]int{
+		"stdin_EOF":  513,
+		"stdin_read": 512,
+		"DEBUG":      514,
+	}
+
+	ioChannelOffset = 515
+)
+
+// IOChannelName returns the name of the IO channel with the given index.
+func IOChannelName(index int) string {
+	switch {
+	case index < stdoutOffset:
+		return fmt.Sprintf("stdin_%02X", index)
+	case index < stdoutOffset+256:
+		return fmt.Sprintf("stdout_%02X", index-stdoutOffset)
+	}
+	for k, i := range miscIOChannels {
+		if i == index {
+			return k
+		}
+	}
+	return ""
+}
+
+// CommandString returns the command of this process without its children.
+// References are printed as indices.
+func (p *Proc) CommandString() string {
+	return p.CommandName(nil)
+}
+
+// CommandName returns the command of this process without its children using
+// the given names for the references in scope.
+func (p *Proc) CommandName(names []string) string {
+	name := func(i int) string {
+		if i >= len(names) || len(names[i]) == 0 {
+			return fmt.Sprintf("%v_", i)
+		}
+		// Count how many times the name is shadowed.
+		shadows := 0
+		for _, n := range names[i+1:] {
+			if n == names[i] {
+				shadows++
+			}
+		}
+		if shadows > 0 {
+			return fmt.Sprintf("%v^%v", names[i], shadows)
+		}
+		return names[i]
+	}
+	// The variable bound by this process is not yet in scope.
+	bind := func(i int) string {
+		if names != nil && len(p.Name) > 0 {
+			return p.Name
+		}
+		return fmt.Sprintf("%v_", i)
+	}
+	switch p.Command {
+	case PINewRef:
+		return fmt.Sprintf("+%v", bind(p.Channel))
+	case PIDeref:
+		return fmt.Sprintf("~%v", name(p.Channel))
+	case PISubsOne:
+		return fmt.Sprintf("%v<-%v", bind(p.Message), name(p.Channel))
+	case PISubsAll:
+		return fmt.Sprintf("%v<<%v", bind(p.Message), name(p.Channel))
+	case PISend:
+		return fmt.Sprintf("%v->%v", name(p.Message), name(p.Channel))
+	}
+	return ""
+}
+
+func (p *Proc) String() string {
+	return procString([]*Proc{p}, nil, nil)
+}
+
+// ProcString returns a string containing all the given processes. Processes
+// are expected to start in the scope of the IO channels and references are
+// printed with their source names.
+func ProcString(proc []*Proc) string {
+	return procString(proc, nil, Scopes(proc))
+}
+
+func procString(proc []*Proc, names []string, scopes map[*Proc][]string) string {
+	strs := make([]string, len(proc))
+	for i, p := range proc {
+		if scopes != nil {
+			names = scopes[p]
+		}
+		command := p.CommandName(names)
+		if len(p.Children) == 0 {
+			strs[i] = fmt.Sprintf("%v.", command)
+		} else {
+			strs[i] = fmt.Sprintf("%v;%v", command, procString(p.Children, nil, scopes))
+		}
+	}
+	switch len(proc) {
+	case 0:
+		return ""
+	case 1:
+		return strs[0]
+	default:
+		return fmt.Sprintf("(%v)", strings.Join(strs, " "))
+	}
+}
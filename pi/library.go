@@ -0,0 +1,117 @@
+package pi
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Options configure where attached files are found. A file in angle brackets
+// (#attach: <nat.pi>) is searched in SearchPath and then in Library. Other
+// files are searched relative to the attaching file first and then in
+// SearchPath. The zero value has no search path and no library.
+type Options struct {
+	SearchPath []string // Directories in which attached files are searched
+	Library    fs.FS    // Standard library (the pi command embeds examples/lib)
+}
+
+// LibraryPrefix is the prefix of the paths of files in the library.
+const LibraryPrefix = "<lib>/"
+
+// ReadFile reads a file from disk or from the library.
+func (o Options) ReadFile(p string) ([]byte, error) {
+	return o.readSource(ioutil.ReadFile, p)
+}
+
+// Return the path of a file that is attached by the file at from (from is
+// empty for files that are not attached). The path is absolute unless the file
+// is in the library.
+func (o Options) resolveAttach(from string, file string) string {
+	if strings.HasPrefix(file, "<") && strings.HasSuffix(file, ">") {
+		name := file[1 : len(file)-1]
+		if p, ok := o.searchFile(name); ok {
+			return p
+		}
+		return LibraryPrefix + path.Clean(name)
+	}
+	var p string
+	switch {
+	case len(from) == 0 || filepath.IsAbs(file):
+		p, _ = filepath.Abs(file)
+	case strings.HasPrefix(from, LibraryPrefix):
+		p = path.Join(path.Dir(from), file)
+	default:
+		p, _ = filepath.Abs(filepath.Join(filepath.Dir(from), file))
+	}
+	if o.fileExists(p) || filepath.IsAbs(file) {
+		return p
+	}
+	if p, ok := o.searchFile(file); ok {
+		return p
+	}
+	return p
+}
+
+// Return the key that identifies a file when files are deduplicated: the
+// library path of a file that has the same name and content as a file in the
+// library, or the path otherwise. Attaching a copy of a library file and the
+// library file then only loads the file once.
+func (o Options) fileKey(p string) string {
+	if o.Library == nil || strings.HasPrefix(p, LibraryPrefix) {
+		return p
+	}
+	var bytes []byte
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	for i := len(parts) - 1; i > 0; i-- {
+		name := strings.Join(parts[i:], "/")
+		lib, err := fs.ReadFile(o.Library, name)
+		if err != nil {
+			continue
+		} else if bytes == nil {
+			if bytes, err = ioutil.ReadFile(p); err != nil {
+				return p
+			}
+		}
+		if string(lib) == string(bytes) {
+			return LibraryPrefix + name
+		}
+	}
+	return p
+}
+
+// Find a file in the search path.
+func (o Options) searchFile(name string) (string, bool) {
+	for _, dir := range o.SearchPath {
+		if p, _ := filepath.Abs(filepath.Join(dir, name)); o.fileExists(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func (o Options) fileExists(p string) bool {
+	if strings.HasPrefix(p, LibraryPrefix) {
+		if o.Library == nil {
+			return false
+		}
+		_, err := fs.Stat(o.Library, p[len(LibraryPrefix):])
+		return err == nil
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// Read a file using read or from the library.
+func (o Options) readSource(read ReadFunc, p string) ([]byte, error) {
+	if strings.HasPrefix(p, LibraryPrefix) {
+		if o.Library == nil {
+			return nil, fmt.Errorf("%v: there is no standard library", p)
+		}
+		return fs.ReadFile(o.Library, p[len(LibraryPrefix):])
+	}
+	return read(p)
+}
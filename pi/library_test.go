@@ -0,0 +1,33 @@
+package pi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// A copy of a library file and the library file are loaded once.
+func TestAttachLibraryCopy(t *testing.T) {
+	bool, err := filepath.Abs("../examples/lib/bool.pi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.pi")
+	source := "#attach: <nat.pi>\n#attach: " + bool + "\n+x;x->x.\n"
+	if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := exampleOptions.Compile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := make([]string, 0)
+	for _, file := range p.Files {
+		if filepath.Base(file) == "bool.pi" {
+			loaded = append(loaded, file)
+		}
+	}
+	if len(loaded) != 1 {
+		t.Errorf("bool.pi is loaded as %v", loaded)
+	}
+}
@@ -0,0 +1,180 @@
+package pi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lint rules (used as diagnostic codes)
+const (
+	RuleUnused          = "unused"           // +x is never used
+	RuleShadow          = "shadow"           // Binding hides a name of an outer block
+	RuleNoReceiver      = "no-receiver"      // Messages are sent on a channel without subscriptions
+	RuleNoSender        = "no-sender"        // Subscription on a channel without senders
+	RuleUndefinedGlobal = "undefined-global" // #global name without subscriptions
+	RuleIODirection     = "io-direction"     // Send on stdin or subscription on stdout
+	RuleAttachCycle     = "attach-cycle"     // Files that attach each other
+)
+
+// LintRules lists all lint rules.
+var LintRules = []string{RuleUnused, RuleShadow, RuleNoReceiver,
+	RuleNoSender, RuleUndefinedGlobal, RuleIODirection, RuleAttachCycle}
+
+// A binding in the linted program
+type lintRef struct {
+	name    string
+	span    Span // Location of the binding (empty for IO channels)
+	kind    uint8
+	depth   int     // Number of enclosing blocks
+	sends   []*Proc // Sends on this channel
+	subs    []*Proc // Subscriptions on this channel
+	escapes bool    // The channel is sent as a message
+}
+
+// Kinds of lint bindings
+const (
+	lintIO uint8 = iota
+	lintGlobal
+	lintNew
+	lintReceive
+)
+
+type linter struct {
+	warnings ErrorList
+	refs     []*lintRef // All source bindings in order
+}
+
+// Lint returns warnings about suspicious code in an analyzed program. Only
+// names from source code are checked (names introduced by the desugaring are
+// skipped). The warnings have a rule ID as code.
+func (a *Analysis) Lint() ErrorList {
+	l := &linter{append(ErrorList{}, a.warnings...), nil}
+	scope := make([]*lintRef, ioChannelOffset)
+	for i := range scope {
+		scope[i] = &lintRef{name: IOChannelName(i), kind: lintIO}
+	}
+	globals := make(map[string]Span)
+	for _, v := range a.Globals {
+		globals[v.Name] = v.Span
+	}
+	l.walk(Analyze(a.Core), scope, globals, 0)
+
+	// Report channels that are only used in one direction.
+	for _, r := range l.refs {
+		switch {
+		case r.kind == lintGlobal && len(r.subs) == 0:
+			l.warn(r.span, RuleUndefinedGlobal,
+				"global %v is declared but never defined", r.name)
+		case r.escapes || r.kind != lintNew:
+			// Other processes may use the channel.
+		case len(r.sends) > 0 && len(r.subs) == 0:
+			l.warn(procSpan(r.sends[0], ""), RuleNoReceiver,
+				"messages are sent on %v but nothing subscribes to it", r.name).
+				Relate(r.span, "%v is bound here", r.name)
+		case len(r.subs) > 0 && len(r.sends) == 0:
+			l.warn(procSpan(r.subs[0], ""), RuleNoSender,
+				"subscription on %v never receives a message", r.name).
+				Relate(r.span, "%v is bound here", r.name)
+		}
+	}
+	return l.warnings
+}
+
+// Walk processes with their optimization info. Globals are recognized as
+// bindings without a location.
+func (l *linter) walk(info ProcInfo, scope []*lintRef, globals map[string]Span, depth int) {
+	if len(info.Proc) > 1 {
+		depth++
+	}
+	for i, p := range info.Proc {
+		pScope := scope
+		switch p.Command {
+		case PINewRef:
+			kind, span := lintNew, procSpan(p, p.Name)
+			if def, ok := globals[p.Name]; ok && len(p.Location.Path) == 0 {
+				kind, span = lintGlobal, def
+			}
+			r := l.bind(p, kind, span, scope, depth)
+			if kind == lintNew && r != nil && !info.Info[i].Used.Contains(p.Channel) {
+				l.warn(span, RuleUnused, "%v is never used", p.Name)
+			}
+			pScope = append(scope[:len(scope):len(scope)], r)
+		case PISubsOne, PISubsAll:
+			c := scope[p.Channel]
+			c.subs = append(c.subs, p)
+			if c.kind == lintIO && isOutputChannel(p.Channel) {
+				l.warn(procSpan(p, ""), RuleIODirection,
+					"subscription on %v never receives a message (it is an output channel)", c.name)
+			}
+			r := l.bind(p, lintReceive, procSpan(p, p.Name), scope, depth)
+			pScope = append(scope[:len(scope):len(scope)], r)
+		case PISend:
+			c := scope[p.Channel]
+			c.sends = append(c.sends, p)
+			if m := scope[p.Message]; m != nil {
+				m.escapes = true
+			}
+			if c.kind == lintIO && isInputChannel(p.Channel) {
+				l.warn(procSpan(p, ""), RuleIODirection,
+					"message sent on %v is never received (it is an input channel)", c.name)
+			}
+		}
+		l.walk(info.Info[i], pScope, globals, depth)
+	}
+}
+
+// Return if the IO channel with index i only receives messages from the
+// program (stdout and stdin_read).
+func isOutputChannel(i int) bool {
+	return (stdoutOffset <= i && i < stdoutOffset+256) || i == miscIOChannels["stdin_read"]
+}
+
+// Return if the IO channel with index i only sends messages to the program
+// (stdin and stdin_EOF).
+func isInputChannel(i int) bool {
+	return i < stdoutOffset || i == miscIOChannels["stdin_EOF"]
+}
+
+// Bind the reference of p. Returns nil for names that are not from the source.
+func (l *linter) bind(p *Proc, kind uint8, span Span, scope []*lintRef, depth int) *lintRef {
+	if len(p.Name) == 0 || strings.HasPrefix(p.Name, "@") || p.Name == "_" {
+		// Channels of the desugaring are not checked, but sends on them are
+		// still recorded.
+		return &lintRef{name: p.Name, kind: lintReceive, escapes: true}
+	}
+	r := &lintRef{name: p.Name, span: span, kind: kind, depth: depth}
+	l.refs = append(l.refs, r)
+
+	// Shadowing within a chain is a common pattern to reuse a name (such as an
+	// acknowledgement channel), so only bindings of outer blocks are reported.
+	if kind == lintGlobal {
+		return r
+	}
+	if _, err := resolveName(p.Name, nil); err == nil {
+		l.warn(span, RuleShadow, "%v shadows an IO channel", p.Name)
+		return r
+	}
+	for i := len(scope) - 1; i >= ioChannelOffset; i-- {
+		if outer := scope[i]; outer.name == p.Name && len(outer.span.Start.Path) > 0 {
+			if outer.kind == lintGlobal || outer.depth < depth {
+				l.warn(span, RuleShadow, "%v shadows a binding of an outer block", p.Name).
+					Relate(outer.span, "%v is bound here", p.Name)
+			}
+			break
+		}
+	}
+	return r
+}
+
+func (l *linter) warn(span Span, rule string, format string, a ...interface{}) *Diagnostic {
+	d := &Diagnostic{SeverityWarning, rule, fmt.Sprintf(format, a...), span, nil, nil}
+	l.warnings = append(l.warnings, d)
+	return d
+}
+
+// Span of a process that starts with the given name.
+func procSpan(p *Proc, name string) Span {
+	end := p.Location
+	end.Col += len(name)
+	return Span{p.Location, end}
+}
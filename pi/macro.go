@@ -0,0 +1,199 @@
+package pi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var macroHeadRE = regexp.MustCompile(`^\s*([a-zA-Z0-9_]+)\s*(?:\(([^()]*)\))?\s*$`)
+
+// Maximum depth of nested macro expansions
+const maxMacroDepth = 100
+
+// Macro is a process template that is defined with a directive:
+//
+//	#define: Name(a,b) = body
+//
+// A use Name(x,y) is replaced by the body in which the parameters are replaced
+// by the arguments (which can be any balanced sequence of tokens). Names that
+// are bound in the body (other than the parameters) are replaced by fresh names
+// in every expansion (name@n) so that they do not capture names of the user
+// (source names cannot contain @).
+type Macro struct {
+	Name   Ident
+	Params []string
+	Body   []Token
+	bound  Set // Names that are bound in the body
+}
+
+// Parse the value of a #define directive. Returns nil if it is invalid.
+func parseMacro(d Directive, errs *ErrorList) *Macro {
+	i := strings.Index(d.Value, "=")
+	if i == -1 {
+		errs.Add(diagnostic(d.Span, CodeMacro, "missing = in macro definition"))
+		return nil
+	}
+	m := macroHeadRE.FindStringSubmatch(d.Value[:i])
+	if m == nil {
+		errs.Add(diagnostic(d.Span, CodeMacro, "invalid macro definition (use Name(a,b) = body)"))
+		return nil
+	}
+	name := Ident{Span{d.Start, Loc{d.Start.Path, d.Start.Ln, d.Start.Col + len(m[1])}}, m[1]}
+	macro := &Macro{name, make([]string, 0), nil, MakeSet()}
+	params := MakeSet()
+	if len(strings.TrimSpace(m[2])) > 0 {
+		for _, p := range strings.Split(m[2], ",") {
+			p = strings.TrimSpace(p)
+			if !checkDeclaration(Ident{d.Span, p}, "define", errs) {
+				return nil
+			} else if params.Contains(p) {
+				errs.Add(diagnostic(d.Span, CodeMacro, "duplicate parameter %v", p))
+				return nil
+			}
+			params.Add(p)
+			macro.Params = append(macro.Params, p)
+		}
+	}
+
+	// Tokenize the body at its location in the directive.
+	col := d.Start.Col + i
+	for _, t := range Tokenize(d.Value[i+1:], Loc{d.Start.Path, d.Start.Ln, 1}) {
+		t.Start.Col += col
+		t.End.Col += col
+		if t.Kind != TokenComment {
+			macro.Body = append(macro.Body, t)
+		}
+	}
+	for _, v := range boundNames(macro.Body) {
+		if !params.Contains(v) {
+			macro.bound.Add(v)
+		}
+	}
+	return macro
+}
+
+// Return the names that are bound by a sequence of tokens: the names after +
+// and the names before a receive operator.
+func boundNames(tokens []Token) []string {
+	names := make([]string, 0)
+	for i, t := range tokens {
+		switch t.Kind {
+		case TokenNew:
+			for j := i + 1; j < len(tokens) && isNameList(tokens[j]); j++ {
+				if tokens[j].Kind == TokenName {
+					names = append(names, tokens[j].Content)
+				}
+			}
+		case TokenReceive, TokenReceiveAll, TokenTunnelReceive, TokenTunnelOne, TokenTunnelAll:
+			for j := i - 1; j >= 0 && isNameList(tokens[j]); j-- {
+				if tokens[j].Kind == TokenName {
+					names = append(names, tokens[j].Content)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// Return if a token is part of a list of names separated by commas.
+func isNameList(t Token) bool {
+	return t.Kind == TokenName || t.Kind == TokenComma
+}
+
+// An expander replaces the uses of macros in tokens.
+type expander struct {
+	macros map[string]*Macro
+	fresh  int // Number of expansions (used for fresh names)
+	errs   *ErrorList
+}
+
+// ExpandMacros replaces the uses of the given macros in tokens.
+func ExpandMacros(tokens []Token, macros map[string]*Macro, errs *ErrorList) []Token {
+	if len(macros) == 0 {
+		return tokens
+	}
+	e := &expander{macros, 0, errs}
+	return e.expand(tokens, 0)
+}
+
+func (e *expander) expand(tokens []Token, depth int) []Token {
+	result := make([]Token, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		m, ok := e.macros[t.Content]
+		if t.Kind != TokenName || !ok || i+1 == len(tokens) || tokens[i+1].Kind != TokenParOpen {
+			result = append(result, t)
+			continue
+		}
+		args, end := macroArgs(tokens, i+1)
+		if end == -1 {
+			e.errs.Add(diagnostic(t.Span, CodeMacro,
+				"missing closing parenthesis in use of macro %v", m.Name.Name))
+			return result
+		}
+		i = end
+		switch {
+		case len(args) != len(m.Params):
+			e.errs.Add(diagnostic(t.Span, CodeMacro, "macro %v expects %v arguments but got %v",
+				m.Name.Name, len(m.Params), len(args)).Relate(m.Name.Span, "%v is defined here", m.Name.Name))
+		case depth == maxMacroDepth:
+			e.errs.Add(diagnostic(t.Span, CodeMacro, "expansion of macro %v is too deep (is it recursive?)",
+				m.Name.Name))
+		default:
+			result = append(result, e.expand(e.substitute(m, args), depth+1)...)
+		}
+	}
+	return result
+}
+
+// Return the arguments of a use of a macro in tokens that starts with a
+// parenthesis at index start and the index of the closing parenthesis (or -1).
+func macroArgs(tokens []Token, start int) ([][]Token, int) {
+	args := make([][]Token, 0)
+	arg := make([]Token, 0)
+	depth := 0
+	for i := start + 1; i < len(tokens); i++ {
+		switch t := tokens[i]; {
+		case t.Kind == TokenParClose && depth == 0:
+			if len(arg) > 0 || len(args) > 0 {
+				args = append(args, arg)
+			}
+			return args, i
+		case t.Kind == TokenComma && depth == 0:
+			args = append(args, arg)
+			arg = make([]Token, 0)
+		default:
+			if t.Kind == TokenParOpen {
+				depth++
+			} else if t.Kind == TokenParClose {
+				depth--
+			}
+			arg = append(arg, t)
+		}
+	}
+	return nil, -1
+}
+
+// Return the body of a macro with the given arguments and fresh names.
+func (e *expander) substitute(m *Macro, args [][]Token) []Token {
+	e.fresh++
+	params := make(map[string][]Token, len(args))
+	for i, p := range m.Params {
+		params[p] = args[i]
+	}
+	body := make([]Token, 0, len(m.Body))
+	for _, t := range m.Body {
+		if t.Kind != TokenName {
+			body = append(body, t)
+		} else if arg, ok := params[t.Content]; ok {
+			body = append(body, arg...)
+		} else if m.bound.Contains(t.Content) {
+			t.Content = t.Content + "@" + strconv.Itoa(e.fresh)
+			body = append(body, t)
+		} else {
+			body = append(body, t)
+		}
+	}
+	return body
+}
@@ -0,0 +1,240 @@
+package pi
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// A sourceFile is a loaded file with the declarations of its directives.
+type sourceFile struct {
+	path     string
+	key      string // Identity of the file (see Options.fileKey)
+	proc     []Process
+	module   Ident             // Module name (empty if the file is not a module)
+	exports  []Ident           // Names exported by a module
+	globals  []Ident           // Global names (private to a module)
+	attach   []attachment      // Attached files
+	macros   map[string]*Macro // Macros by name (private to the file)
+	warnings ErrorList         // Attach cycles
+	names    map[string]int    // Global indices of the names of a module
+	scope    map[string]int    // Global indices of all visible names
+}
+
+// An attachment is an #attach directive with an optional alias.
+type attachment struct {
+	Span
+	file  string // File as written in the directive
+	path  string // Resolved path
+	key   string // Identity of the file (see Options.fileKey)
+	alias Ident
+}
+
+// Read the declarations of a file at path from its directives.
+func parseSourceFile(opts Options, path string, key string, directives []Directive,
+	errs *ErrorList) *sourceFile {
+	f := &sourceFile{path: path, key: key, macros: make(map[string]*Macro)}
+	for _, d := range directives {
+		checkDirective(d, errs)
+		v := Ident{d.Span, d.Value}
+		switch d.Key {
+		case "module":
+			if len(f.module.Name) > 0 {
+				errs.Add(diagnostic(d.Span, CodeDirective, "duplicate #module directive").
+					Relate(f.module.Span, "the module is declared here"))
+			} else if checkDeclaration(v, d.Key, errs) {
+				f.module = v
+			}
+		case "export":
+			if checkDeclaration(v, d.Key, errs) {
+				f.exports = append(f.exports, v)
+			}
+		case "global":
+			if checkDeclaration(v, d.Key, errs) {
+				f.globals = append(f.globals, v)
+			}
+		case "attach":
+			file, alias := splitAttach(d.Value)
+			p := opts.resolveAttach(path, file)
+			a := attachment{d.Span, file, p, opts.fileKey(p), Ident{}}
+			if len(alias) > 0 {
+				start := Loc{path, d.End.Ln, d.End.Col - len(alias)}
+				a.alias = Ident{Span{start, d.End}, alias}
+				if !checkDeclaration(a.alias, "attach", errs) {
+					a.alias = Ident{}
+				}
+			}
+			f.attach = append(f.attach, a)
+		case "define":
+			m := parseMacro(d, errs)
+			if m == nil {
+				continue
+			} else if other, ok := f.macros[m.Name.Name]; ok {
+				errs.Add(diagnostic(m.Name.Span, CodeConflict, "macro %v is already defined", m.Name.Name).
+					Relate(other.Name.Span, "%v is defined here", m.Name.Name))
+			} else {
+				f.macros[m.Name.Name] = m
+			}
+		}
+	}
+	if len(f.exports) > 0 && len(f.module.Name) == 0 {
+		errs.Add(diagnostic(f.exports[0].Span, CodeDirective, "#export requires a #module directive"))
+	}
+	return f
+}
+
+// Report a directive with an unknown or missing key. Test cases (#test) are
+// read by pi test.
+func checkDirective(d Directive, errs *ErrorList) {
+	switch d.Key {
+	case "module", "export", "global", "attach", "define", "test":
+	case "":
+		errs.Add(diagnostic(d.Span, CodeDirective, "expected #key: value, found %v", d.Value))
+	default:
+		errs.Add(diagnostic(d.Span, CodeDirective, "unknown directive #%v", d.Key))
+	}
+}
+
+// Split the value of an #attach directive into the path and the alias.
+func splitAttach(value string) (string, string) {
+	if i := strings.LastIndex(value, " as "); i != -1 {
+		return strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+4:])
+	}
+	return value, ""
+}
+
+// Check that a declared name is an unqualified name.
+func checkDeclaration(v Ident, key string, errs *ErrorList) bool {
+	for i := 0; i < len(v.Name); i++ {
+		if !isNameChar(v.Name[i]) {
+			errs.Add(diagnostic(v.Span, CodeName, "invalid name %q in #%v directive", v.Name, key))
+			return false
+		}
+	}
+	if len(v.Name) == 0 {
+		errs.Add(diagnostic(v.Span, CodeName, "missing name in #%v directive", key))
+		return false
+	}
+	return true
+}
+
+// A linker assigns a global channel to every declaration of the loaded files
+// and computes the names that are visible in every file. Names that are
+// declared with #global outside a module are visible in all files. The names
+// of a module are qualified by the module name (nat::add). In the module itself
+// they are also visible without qualification, and files that attach the
+// module see its exports qualified by the module name or the alias of the
+// #attach directive.
+type linker struct {
+	globals []Ident                // Global names in order of declaration
+	flat    map[string]int         // Global indices of names outside modules
+	modules map[string]*sourceFile // Modules by name
+	files   map[string]*sourceFile // Linked files by key
+}
+
+func newLinker() *linker {
+	return &linker{nil, make(map[string]int), make(map[string]*sourceFile),
+		make(map[string]*sourceFile)}
+}
+
+// Link declares the names of the given files and computes their scopes. The
+// files may attach files that were linked before. Conflicting declarations
+// are added to errs.
+func (l *linker) Link(files []*sourceFile, errs *ErrorList) {
+	for _, f := range files {
+		l.declare(f, errs)
+	}
+	for _, f := range files {
+		l.resolve(f, errs)
+	}
+}
+
+// Add a global name and return its index.
+func (l *linker) add(v Ident) int {
+	l.globals = append(l.globals, v)
+	return len(l.globals) - 1
+}
+
+func (l *linker) declare(f *sourceFile, errs *ErrorList) {
+	l.files[f.key] = f
+	if len(f.module.Name) == 0 {
+		for _, v := range f.globals {
+			if i, ok := l.flat[v.Name]; ok {
+				errs.Add(diagnostic(v.Span, CodeConflict, "global %v is already declared", v.Name).
+					Relate(l.globals[i].Span, "%v is declared here", v.Name))
+			} else {
+				l.flat[v.Name] = l.add(v)
+			}
+		}
+		return
+	}
+
+	if m, ok := l.modules[f.module.Name]; ok {
+		errs.Add(diagnostic(f.module.Span, CodeConflict, "module %v is already declared", f.module.Name).
+			Relate(m.module.Span, "%v is declared here", f.module.Name))
+	} else {
+		l.modules[f.module.Name] = f
+	}
+	f.names = make(map[string]int)
+	for _, v := range append(f.exports[:len(f.exports):len(f.exports)], f.globals...) {
+		if i, ok := f.names[v.Name]; ok {
+			errs.Add(diagnostic(v.Span, CodeConflict, "%v is already declared in module %v",
+				v.Name, f.module.Name).Relate(l.globals[i].Span, "%v is declared here", v.Name))
+		} else {
+			f.names[v.Name] = l.add(Ident{v.Span, f.module.Name + sQualifier + v.Name})
+		}
+	}
+}
+
+func (l *linker) resolve(f *sourceFile, errs *ErrorList) {
+	f.scope = make(map[string]int)
+	for name, i := range l.flat {
+		f.scope[name] = i
+	}
+	// Modules by qualifier
+	modules := make(map[string]*sourceFile)
+	if len(f.module.Name) > 0 {
+		for _, v := range append(f.exports[:len(f.exports):len(f.exports)], f.globals...) {
+			if l.globals[f.names[v.Name]].Span != v.Span {
+				// Duplicate declaration
+				continue
+			}
+			if i, ok := l.flat[v.Name]; ok {
+				errs.Add(diagnostic(v.Span, CodeConflict, "%v in module %v conflicts with global %v",
+					v.Name, f.module.Name, v.Name).Relate(l.globals[i].Span, "%v is declared here", v.Name))
+			}
+		}
+		for name, i := range f.names {
+			f.scope[name] = i
+		}
+		modules[f.module.Name] = f
+	}
+	for _, a := range f.attach {
+		m, ok := l.files[a.key]
+		if !ok {
+			continue
+		} else if len(m.module.Name) == 0 {
+			if len(a.alias.Name) > 0 {
+				errs.Add(diagnostic(a.alias.Span, CodeDirective, "%v is not a module",
+					filepath.Base(a.path)))
+			}
+			continue
+		}
+		qualifier := a.alias
+		if len(qualifier.Name) == 0 {
+			qualifier = Ident{a.Span, m.module.Name}
+		}
+		if other, ok := modules[qualifier.Name]; ok && other != m {
+			errs.Add(diagnostic(qualifier.Span, CodeConflict, "%v already refers to module %v in %v",
+				qualifier.Name, other.module.Name, filepath.Base(other.path)))
+			continue
+		}
+		modules[qualifier.Name] = m
+	}
+	for qualifier, m := range modules {
+		for _, v := range m.exports {
+			if i, ok := m.names[v.Name]; ok {
+				f.scope[qualifier+sQualifier+v.Name] = i
+			}
+		}
+	}
+}
@@ -1,4 +1,6 @@
-package main
+package pi
+
+import "fmt"
 
 // ProcInfo contains information about a list of processes. It contains a set of
 // all reference indices that are used, and an info object for the children of
@@ -28,28 +30,41 @@ func Analyze(proc []*Proc) ProcInfo {
 }
 
 // Optimize inserts PIDeref commands to deference unused channels.
-func Optimize(program []*Proc) []*Proc {
-	// Analyze program and generate initial IO references.
+func Optimize(program []*Proc) ([]*Proc, error) {
+	return OptimizeScope(program, ioChannelOffset)
+}
+
+// OptimizeScope is like Optimize for a program that starts with the given
+// number of references (the IO channels followed by any predefined channels).
+func OptimizeScope(program []*Proc, scope int) ([]*Proc, error) {
+	// Analyze program and generate initial references.
 	info := Analyze(program)
-	refs := make([]int, ioChannelOffset)
-	for i := 0; i < ioChannelOffset; i++ {
+	refs := make([]int, scope)
+	names := make([]string, scope)
+	for i := 0; i < scope; i++ {
 		refs[i] = i
+		names[i] = IOChannelName(i)
 	}
-	return optimize(info, refs, ioChannelOffset)
+	return optimize(info, refs, names, scope)
 }
 
-func optimize(info ProcInfo, refs []int, refSeq int) []*Proc {
+// The names slice contains the source name of each reference in refs.
+func optimize(info ProcInfo, refs []int, names []string, refSeq int) ([]*Proc, error) {
 	// Do not dereference when there are not child processes.
 	if len(info.Proc) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Determine which indices in refs are not used in any of the child processes.
 	deref := []int{}
+	derefNames := []string{}
+	names = append(names[:0:0], names...)
 	for i := 0; i < len(refs); i++ {
 		if !info.Used.Contains(refs[i]) {
 			deref = append(deref, i)
+			derefNames = append(derefNames, names[i])
 			refs = append(refs[:i], refs[i+1:]...)
+			names = append(names[:i], names[i+1:]...)
 			i--
 		}
 	}
@@ -57,36 +72,46 @@ func optimize(info ProcInfo, refs []int, refSeq int) []*Proc {
 	children := make([]*Proc, len(info.Proc))
 	for i, p := range info.Proc {
 		pRefs := append(refs[:0:0], refs...)
+		pNames := append(names[:0:0], names...)
 		pRefSeq := refSeq
 		// Add new references to the refs slice (note that we need refSeq to compute
 		// the reference index in the unoptimized program).
 		if p.Command&(PINewRef|PISubsOne|PISubsAll) != 0 {
 			pRefs = append(pRefs, pRefSeq)
+			pNames = append(pNames, p.Name)
 			pRefSeq++
 		}
 		// Create new process node.
-		children[i] = &Proc{p.Location, p.Command,
-			lookupRef(p.Channel, pRefs),
-			lookupRef(p.Message, pRefs),
-			optimize(info.Info[i], pRefs, pRefSeq),
+		channel, err := lookupRef(p.Channel, pRefs)
+		if err != nil {
+			return nil, fmt.Errorf("%v; %v", p.Location, err)
+		}
+		message, err := lookupRef(p.Message, pRefs)
+		if err != nil {
+			return nil, fmt.Errorf("%v; %v", p.Location, err)
+		}
+		grandchildren, err := optimize(info.Info[i], pRefs, pNames, pRefSeq)
+		if err != nil {
+			return nil, err
 		}
+		children[i] = &Proc{p.Location, p.Command, channel, message, grandchildren, p.Name}
 	}
 	// Prepend dereference nodes.
 	proc := children
 	for i := len(deref) - 1; i >= 0; i-- {
-		proc = []*Proc{&Proc{Loc{}, PIDeref, deref[i], -1, proc}}
+		proc = []*Proc{&Proc{Loc{}, PIDeref, deref[i], -1, proc, derefNames[i]}}
 	}
-	return proc
+	return proc, nil
 }
 
-func lookupRef(ref int, refs []int) int {
+func lookupRef(ref int, refs []int) (int, error) {
 	if ref == -1 {
-		return -1
+		return -1, nil
 	}
 	for i, r := range refs {
 		if r == ref {
-			return i
+			return i, nil
 		}
 	}
-	panic("ref not found")
+	return -1, fmt.Errorf("reference %v not found", ref)
 }
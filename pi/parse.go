@@ -0,0 +1,320 @@
+package pi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Parse builds the surface syntax tree of a list of parallel processes.
+// Comments are skipped. Errors are added to err and parsing continues after the
+// next semicolon or period.
+func Parse(tokens []Token, err *ErrorList) []Process {
+	p := newParser(tokens, err)
+	proc := p.list()
+	if t := p.peek(); t.Kind != TokenEOF {
+		err.Add(diagnostic(t.Span, CodeUnexpected, "unexpected %v", t))
+	}
+	return proc
+}
+
+// Syntactic forms of the command operators
+var operatorForms = map[TokenKind]Form{
+	TokenReceive:       FormReceive,
+	TokenReceiveAll:    FormReceiveAll,
+	TokenSend:          FormSend,
+	TokenTrigger:       FormTrigger,
+	TokenForward:       FormForward,
+	TokenTunnelSend:    FormTunnelSend,
+	TokenTunnelReceive: FormTunnelReceive,
+	TokenTunnelOne:     FormTunnelOne,
+	TokenTunnelAll:     FormTunnelAll,
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+	err    *ErrorList
+}
+
+// Create a parser for the given tokens without comments. Source names cannot
+// contain @ (it is reserved for generated names); an @ is reported once and the
+// name around it is parsed as usual.
+func newParser(tokens []Token, err *ErrorList) *parser {
+	p := &parser{make([]Token, 0, len(tokens)), 0, err}
+	for _, t := range tokens {
+		if t.Kind == TokenComment {
+			continue
+		} else if t.Kind == TokenInvalid && t.Content == "@" {
+			err.Add(diagnostic(t.Span, CodeName, "@ is reserved for generated names"))
+			t.Kind = TokenName
+		}
+		if n := len(p.tokens); n > 0 && t.Kind == TokenName {
+			last := &p.tokens[n-1]
+			if last.Kind == TokenName && last.End == t.Start &&
+				(t.Content == "@" || strings.HasSuffix(last.Content, "@")) {
+				last.End = t.End
+				last.Content += t.Content
+				continue
+			}
+		}
+		p.tokens = append(p.tokens, t)
+	}
+	return p
+}
+
+// Return the current token (or an EOF token after the last token).
+func (p *parser) peek() Token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	end := Loc{}
+	if len(p.tokens) > 0 {
+		end = p.tokens[len(p.tokens)-1].End
+	}
+	return Token{Span{end, end}, TokenEOF, ""}
+}
+
+func (p *parser) next() Token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+// Parse processes until a closing parenthesis or the end.
+func (p *parser) list() []Process {
+	proc := make([]Process, 0)
+	for k := p.peek().Kind; k != TokenEOF && k != TokenParClose; k = p.peek().Kind {
+		if q := p.process(); q != nil {
+			proc = append(proc, q)
+		}
+	}
+	return proc
+}
+
+// Parse a block or an action. Returns nil if the process has errors.
+func (p *parser) process() Process {
+	start := p.peek()
+	if start.Kind == TokenParOpen {
+		p.next()
+		body := p.list()
+		end := p.peek()
+		if end.Kind != TokenParClose {
+			p.err.Add(diagnostic(end.Span, CodeUnclosed, "missing closing parenthesis").
+				Relate(start.Span, "unclosed parenthesis"))
+			return &Block{Span{start.Start, end.Start}, body}
+		}
+		p.next()
+		return &Block{Span{start.Start, end.End}, body}
+	}
+
+	c, ok := p.command()
+	if !ok {
+		p.skip()
+		return nil
+	}
+	a := &Action{c.Span, c, nil}
+	switch t := p.peek(); t.Kind {
+	case TokenPeriod:
+		p.next()
+		a.End = t.End
+	case TokenSemicolon:
+		p.next()
+		a.End = t.End
+		if k := p.peek().Kind; k == TokenEOF || k == TokenParClose {
+			p.err.Add(diagnostic(t.Span, CodeUnexpected, "expected process after semicolon"))
+		} else if a.Next = p.process(); a.Next != nil {
+			a.End = a.Next.Range().End
+		}
+	default:
+		p.err.Add(diagnostic(t.Span, CodeTerminator, "expected semicolon or period, found %v", t).
+			Relate(c.Span, "after this command"))
+	}
+	return a
+}
+
+// Parse a command. Returns false if the command has errors.
+func (p *parser) command() (Command, bool) {
+	c := Command{Span: Span{p.peek().Start, p.peek().Start}}
+	if p.peek().Kind == TokenNew {
+		p.next()
+		c.Form = FormCreate
+		c.Args = p.names()
+		if !p.named(c.Args) {
+			return c, false
+		}
+		if p.peek().Kind == TokenSend {
+			p.next()
+			c.Form = FormCreateSend
+			c.Targets = p.names()
+			if !p.named(c.Targets) {
+				return c, false
+			}
+		}
+		c.End = p.tokens[p.pos-1].End
+		return c, true
+	}
+
+	c.Args = p.names()
+	op := p.peek()
+	form, isOperator := operatorForms[op.Kind]
+	if !isOperator {
+		p.err.Add(diagnostic(op.Span, CodeUnexpected, "unexpected %v", op))
+		return c, false
+	}
+	p.next()
+	c.Form = form
+	c.Targets = p.names()
+
+	// Check the number of names.
+	ok := true
+	switch form {
+	case FormReceiveAll:
+		ok = p.single(c.Args, false) && p.single(c.Targets, true)
+	case FormSend:
+		ok = p.named(c.Targets)
+	case FormTrigger:
+		if len(c.Args) > 1 || len(c.Args[0].Name) > 0 {
+			p.err.Add(diagnostic(Span{c.Start, op.Start}, CodeNameCount, "unexpected names before %v", op))
+			ok = false
+		}
+		ok = ok && p.single(c.Targets, true)
+	case FormForward:
+		ok = p.single(c.Args, true) && p.named(c.Targets)
+	default:
+		ok = p.single(c.Targets, true)
+	}
+	c.End = p.tokens[p.pos-1].End
+	return c, ok
+}
+
+// Parse a comma separated list of names in which names may be omitted.
+func (p *parser) names() []Ident {
+	names := make([]Ident, 0, 1)
+	for {
+		t := p.peek()
+		if t.Kind == TokenName {
+			p.next()
+			names = append(names, Ident{t.Span, t.Content})
+		} else {
+			names = append(names, Ident{Span{t.Start, t.Start}, ""})
+		}
+		if p.peek().Kind != TokenComma {
+			return names
+		}
+		p.next()
+	}
+}
+
+// Check that no name is omitted.
+func (p *parser) named(names []Ident) bool {
+	for _, v := range names {
+		if len(v.Name) == 0 {
+			t := p.at(v.Start)
+			p.err.Add(diagnostic(t.Span, CodeName, "expected name, found %v", t))
+			return false
+		}
+	}
+	return true
+}
+
+// Check that there is a single name (that is not omitted if required).
+func (p *parser) single(names []Ident, required bool) bool {
+	if len(names) > 1 {
+		span := Span{names[1].Start, names[len(names)-1].End}
+		p.err.Add(diagnostic(span, CodeNameCount, "expected a single name"))
+		return false
+	}
+	return !required || p.named(names)
+}
+
+// Return the token at the given location.
+func (p *parser) at(loc Loc) Token {
+	for _, t := range p.tokens {
+		if t.Start == loc {
+			return t
+		}
+	}
+	return Token{Span{loc, loc}, TokenEOF, ""}
+}
+
+// Skip tokens until after the next semicolon or period, or until a parenthesis.
+func (p *parser) skip() {
+	for {
+		switch p.peek().Kind {
+		case TokenEOF, TokenParOpen, TokenParClose:
+			return
+		case TokenSemicolon, TokenPeriod:
+			p.next()
+			return
+		}
+		p.next()
+	}
+}
+
+// Check if a name is bound or if it is an IO channel.
+func resolveName(name string, bound map[string]int) (int, error) {
+	// Check if the name is bound.
+	if index, isBound := bound[name]; isBound {
+		return index, nil
+	}
+	// Hexadecimal stdin/stdout
+	offset := 0
+	m := stdinHexRE.FindStringSubmatch(name)
+	if len(m) == 0 {
+		offset = stdoutOffset
+		m = stdoutHexRE.FindStringSubmatch(name)
+	}
+	if len(m) != 0 {
+		v, _ := hex.DecodeString(m[1])
+		return offset + int(v[0]), nil
+	}
+	// Alphanumeric stdin/stdout
+	offset = 0
+	m = stdinAlphaNumRE.FindStringSubmatch(name)
+	if len(m) == 0 {
+		offset = stdoutOffset
+		m = stdoutAlphaNumRE.FindStringSubmatch(name)
+	}
+	if len(m) != 0 {
+		b := byte(m[1][0])
+		return offset + int(b), nil
+	}
+	// Other IO channels
+	for k, index := range miscIOChannels {
+		if name == k {
+			return index, nil
+		}
+	}
+	// Note that 0 refers to the NIL stdin channel.
+	return 0, fmt.Errorf("unbound variable")
+}
+
+// ErrorList keeps track of errors using this error list.
+type ErrorList []error
+
+// Add error. Diagnostics with the same span and message as an earlier
+// diagnostic are ignored.
+func (l *ErrorList) Add(err error) {
+	if err == nil {
+		return
+	}
+	if d, ok := err.(*Diagnostic); ok {
+		for _, e := range *l {
+			if e, ok := e.(*Diagnostic); ok && e.Span == d.Span && e.Message == d.Message {
+				return
+			}
+		}
+	}
+	*l = append(*l, err)
+}
+
+func (l ErrorList) Error() string {
+	strs := make([]string, len(l))
+	for i, err := range l {
+		strs[i] = err.Error()
+	}
+	return strings.Join(strs, "\n")
+}
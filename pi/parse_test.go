@@ -0,0 +1,217 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata.")
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		source string
+		tokens string // Tokens as content@column
+	}{
+		{"x<-y.", "x@1 <-@2 y@4 .@5"},
+		{"+a,b;<>a. !comment", "+@1 a@2 ,@3 b@4 ;@5 <>@6 a@8 .@9 !comment@11"},
+		{"x<<<y;z>->w.", "x@1 <<<@2 y@5 ;@6 z@7 >->@8 w@11 .@12"},
+		{"nat::add->r.", "nat::add@1 ->@9 r@11 .@12"},
+		{"a:: b", "a@1 :@2 :@3 b@5"},
+		{"a@1", "a@1 @@2 1@3"},
+	}
+	for _, test := range tests {
+		strs := make([]string, 0)
+		for _, t := range Tokenize(test.source, Loc{"test.pi", 1, 1}) {
+			strs = append(strs, fmt.Sprintf("%v@%v", t.Content, t.Start.Col))
+		}
+		if got := strings.Join(strs, " "); got != test.tokens {
+			t.Errorf("Tokenize(%q) = %v, want %v", test.source, got, test.tokens)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		errors string // Codes and columns of the errors
+	}{
+		{"x<-y.", ""},
+		{"x<-y", "E003:5"},
+		{"(x<-y.", "E002:7"},
+		{"x<-y.)", "E001:6"},
+		{"x<<y,z.", "E005:6"},
+		{"a<>b.", "E005:1"},
+		{"<>.", "E004:3"},
+		{"+a@1;<>a.", "E004:3"},
+		{"x<-y. $", "E001:7"},
+	}
+	for _, test := range tests {
+		errs := ErrorList([]error{})
+		Parse(Tokenize(test.source, Loc{"test.pi", 1, 1}), &errs)
+		strs := make([]string, 0)
+		for _, d := range Diagnostics(errs) {
+			strs = append(strs, fmt.Sprintf("%v:%v", d.Code, d.Span.Start.Col))
+		}
+		if got := strings.Join(strs, " "); got != test.errors {
+			t.Errorf("Parse(%q) errors = %v, want %v", test.source, got, test.errors)
+		}
+	}
+}
+
+func TestDesugar(t *testing.T) {
+	tests := []struct {
+		source string
+		core   string
+	}{
+		{"+x;x->x.", "+x;x->x."},
+		{"+x,y;y->x.", "+x;+y;y->x."},
+		{"+x;<>x.", "+x;+@4;@4->x;@1<-@4."},
+		{"+x;x->stdout_41.", "+x;x->stdout_41."},
+		{"+x,y,z;x->y,z.", "+x;+y;+z;x->y;x->z."},
+		{"+x;y<-x;z<-y.", "+x;y<-x;z<-y."},
+		{"+x;y<<x;z<-y.", "+x;y<<x;z<-y."},
+		{"+x,y;x>>y.", "+x;+y;@5<<x;@5->y."},
+		{"+x,y;x>->y.", "+x;+y;+@6a;@6a->y;@6b<-@6a;x->@6b."},
+		{"+x,y;z<-<x;z->y.", "+x;+y;+@7;@7->x;z<-@7;z->y."},
+		{"+x,y;z<<-x;z->y.", "+x;+y;@8a<-x;+@8b;@8b->@8a;z<-@8b;z->y."},
+		{"+x,y;z<<<x;z->y.", "+x;+y;@9a<<x;+@9b;@9b->@9a;z<-@9b;z->y."},
+	}
+	for _, test := range tests {
+		errs := ErrorList([]error{})
+		proc := Desugar(Parse(Tokenize(test.source, Loc{"test.pi", 1, 1}), &errs),
+			ioChannelOffset, make(map[string]int), &errs)
+		if len(errs) > 0 {
+			t.Errorf("Desugar(%q): %v", test.source, errs)
+		} else if got := ProcString(proc); got != test.core {
+			t.Errorf("Desugar(%q) = %v, want %v", test.source, got, test.core)
+		}
+	}
+}
+
+// The examples are formatted, so formatting them returns the source.
+func TestFormatExamples(t *testing.T) {
+	files, _ := filepath.Glob("../examples/*.pi")
+	lib, _ := filepath.Glob("../examples/lib/*.pi")
+	for _, file := range append(files, lib...) {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := Format(string(source), file); err != nil {
+			t.Errorf("%v: %v", file, err)
+		} else if got != string(source) {
+			t.Errorf("%v is not formatted", file)
+		}
+	}
+}
+
+// The core of the examples is compared with testdata/*.core.
+func TestDesugarExamples(t *testing.T) {
+	for _, name := range examplePrograms {
+		p, err := compileExample(name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		golden(t, "testdata/"+name+".core", ProcString(p.Core)+"\n")
+	}
+}
+
+// Compare got with the golden file (or update the file with -update).
+func golden(t *testing.T, path string, got string) {
+	t.Helper()
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%v differs from the output (run go test -update)", path)
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	tests := []struct {
+		line      string
+		directive string // Key, value and column of the value
+	}{
+		{"#attach: a.pi", "attach|a.pi|10"},
+		{"#attach: a.pi ! comment", "attach|a.pi|10"},
+		{"#define: F(x) = x->nat::add.", "define|F(x) = x->nat::add.|10"},
+		{`#test: "a:b!" "c" ! comment`, `test|"a:b!" "c"|8`},
+		{"#attach a.pi", "|#attach a.pi|1"},
+	}
+	for _, test := range tests {
+		directives, offset, _ := ParseDirectives(test.line, "test.pi")
+		got := ""
+		for _, d := range directives {
+			got = fmt.Sprintf("%v|%v|%v", d.Key, d.Value, d.Start.Col)
+		}
+		if got != test.directive || offset != 1 {
+			t.Errorf("ParseDirectives(%q) = %v (offset %v), want %v",
+				test.line, got, offset, test.directive)
+		}
+	}
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		errors string // Codes and lines of the errors
+	}{
+		{"#global: x\n+y;x->y.", ""},
+		{"#glob: x\n+y;y->y.", "E007:1"},
+		{"#global x\n+y;y->y.", "E007:1"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "test.pi")
+		if err := ioutil.WriteFile(path, []byte(test.source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		strs := make([]string, 0)
+		if _, err := Compile(path); err != nil {
+			for _, d := range Diagnostics(err) {
+				strs = append(strs, fmt.Sprintf("%v:%v", d.Code, d.Span.Start.Ln))
+			}
+		}
+		if got := strings.Join(strs, " "); got != test.errors {
+			t.Errorf("Compile(%q) errors = %v, want %v", test.source, got, test.errors)
+		}
+	}
+}
+
+// A macro body can use qualified names.
+func TestMacroQualifiedName(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"m.pi":    "#module: m\n#export: out\nc<<out;c->stdout__A.\n",
+		"test.pi": "#attach: m.pi\n#define: Write(x) = x->m::out.\n+c;Write(c)\n",
+	}
+	for name, source := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := Compile(filepath.Join(dir, "test.pi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := p.Run(context.Background(), strings.NewReader(""), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "A" {
+		t.Errorf("output %q, want %q", out.String(), "A")
+	}
+}
@@ -0,0 +1,293 @@
+package pi
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Profiler is a tracer that counts how often every process is executed and
+// copied (listeners of all messages are copied for every message) and how
+// many messages are delivered and deferred on every channel. Channels are
+// identified by the process that created them or by their IO name.
+// Dereferences that are inserted by the optimizer are not counted.
+type Profiler struct {
+	Scopes   map[*Proc][]string // Optional names for commands
+	Procs    map[*Proc]*ProcProfile
+	Channels map[ChannelSite]*ChannelProfile
+
+	parents map[*Proc]*Proc
+}
+
+// ProcProfile contains the counts of a process.
+type ProcProfile struct {
+	Execs  uint64 // Number of executions
+	Copies uint64 // Number of copies of a PISubsAll listener
+}
+
+// ChannelSite identifies channels by the process that created them or by the
+// IO index (for IO channels Proc is nil).
+type ChannelSite struct {
+	Proc    *Proc
+	IOIndex int
+}
+
+// ChannelProfile contains the counts of the channels of a site.
+type ChannelProfile struct {
+	Delivered uint64 // Number of delivered messages
+	Deferred  uint64 // Number of times a message was deferred to the next cycle
+}
+
+// NewProfiler creates a profiler for a program.
+func NewProfiler(proc []*Proc) *Profiler {
+	parents := make(map[*Proc]*Proc)
+	var walk func(parent *Proc, proc []*Proc)
+	walk = func(parent *Proc, proc []*Proc) {
+		for _, p := range proc {
+			parents[p] = parent
+			if p.Command == PIDeref {
+				walk(parent, p.Children)
+			} else {
+				walk(p, p.Children)
+			}
+		}
+	}
+	walk(nil, proc)
+	return &Profiler{nil, make(map[*Proc]*ProcProfile),
+		make(map[ChannelSite]*ChannelProfile), parents}
+}
+
+// Trace counts events.
+func (p *Profiler) Trace(e Event) {
+	switch e.Type {
+	case EventExec:
+		if e.Proc.Command != PIDeref {
+			p.proc(e.Proc).Execs++
+		}
+	case EventCopy:
+		p.proc(e.Proc).Copies++
+	case EventDeliver:
+		p.channel(e.Channel).Delivered++
+	case EventDefer:
+		p.channel(e.Channel).Deferred++
+	}
+}
+
+func (p *Profiler) proc(proc *Proc) *ProcProfile {
+	pp, ok := p.Procs[proc]
+	if !ok {
+		pp = &ProcProfile{}
+		p.Procs[proc] = pp
+	}
+	return pp
+}
+
+func (p *Profiler) channel(c *Channel) *ChannelProfile {
+	site := ChannelSite{c.Site, c.IOIndex}
+	cp, ok := p.Channels[site]
+	if !ok {
+		cp = &ChannelProfile{}
+		p.Channels[site] = cp
+	}
+	return cp
+}
+
+// Return the location and the name of a channel site.
+func (p *Profiler) siteName(site ChannelSite) (string, string) {
+	if site.Proc == nil {
+		return "", IOChannelName(site.IOIndex)
+	}
+	name := site.Proc.Name
+	if names := p.Scopes[site.Proc]; len(name) == 0 && site.Proc.Channel < len(names) {
+		name = names[site.Proc.Channel]
+	}
+	return site.Proc.Location.String(), name
+}
+
+// WriteReport writes the processes sorted by the number of executions and the
+// channels sorted by the number of delivered messages.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	procs := make([]*Proc, 0, len(p.Procs))
+	for proc := range p.Procs {
+		procs = append(procs, proc)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		a, b := p.Procs[procs[i]], p.Procs[procs[j]]
+		if a.Execs != b.Execs {
+			return a.Execs > b.Execs
+		}
+		return a.Copies > b.Copies ||
+			a.Copies == b.Copies && locLess(procs[i].Location, procs[j].Location)
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%10v\t%10v\tlocation\tcommand\n", "execs", "copies")
+	for _, proc := range procs {
+		pp := p.Procs[proc]
+		fmt.Fprintf(tw, "%10v\t%10v\t%v\t%v\n", pp.Execs, pp.Copies,
+			proc.Location, proc.CommandName(p.Scopes[proc]))
+	}
+	fmt.Fprintln(tw)
+
+	sites := make([]ChannelSite, 0, len(p.Channels))
+	for site := range p.Channels {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		a, b := p.Channels[sites[i]], p.Channels[sites[j]]
+		if a.Delivered != b.Delivered {
+			return a.Delivered > b.Delivered
+		} else if a.Deferred != b.Deferred {
+			return a.Deferred > b.Deferred
+		}
+		x, y := sites[i], sites[j]
+		if x.Proc == nil || y.Proc == nil {
+			return x.Proc == nil && (y.Proc != nil || x.IOIndex < y.IOIndex)
+		}
+		return locLess(x.Proc.Location, y.Proc.Location)
+	})
+	fmt.Fprintf(tw, "%10v\t%10v\tlocation\tchannel\n", "delivered", "deferred")
+	for _, site := range sites {
+		cp := p.Channels[site]
+		loc, name := p.siteName(site)
+		fmt.Fprintf(tw, "%10v\t%10v\t%v\t%v\n", cp.Delivered, cp.Deferred, loc, name)
+	}
+	return tw.Flush()
+}
+
+func locLess(a Loc, b Loc) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	} else if a.Ln != b.Ln {
+		return a.Ln < b.Ln
+	}
+	return a.Col < b.Col
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format of pprof.
+// Every process is a function at its source location; the enclosing processes
+// are its callers. A sample of a channel is attributed to the process that
+// created it. The sample values are execs (the default), copies, delivered and
+// deferred.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	var b protoBuffer
+	strs := map[string]int{"": 0}
+	table := []string{""}
+	str := func(s string) uint64 {
+		i, ok := strs[s]
+		if !ok {
+			i = len(table)
+			strs[s] = i
+			table = append(table, s)
+		}
+		return uint64(i)
+	}
+	for _, t := range []string{"execs", "copies", "delivered", "deferred"} {
+		var vt protoBuffer
+		vt.uint(1, str(t))
+		vt.uint(2, str("count"))
+		b.bytes(1, vt)
+	}
+
+	// Every process and IO channel has a location with a function.
+	ids := make(map[*Proc]uint64)
+	ioIDs := make(map[int]uint64)
+	function := func(id uint64, name string, file string, line int) {
+		var f, l, loc protoBuffer
+		f.uint(1, id)
+		f.uint(2, str(name))
+		f.uint(3, str(name))
+		f.uint(4, str(file))
+		f.uint(5, uint64(line))
+		b.bytes(5, f)
+		l.uint(1, id)
+		l.uint(2, uint64(line))
+		loc.uint(1, id)
+		loc.bytes(4, l)
+		b.bytes(4, loc)
+	}
+	var stack func(proc *Proc) []uint64
+	stack = func(proc *Proc) []uint64 {
+		if proc == nil {
+			return nil
+		}
+		id, ok := ids[proc]
+		if !ok {
+			id = uint64(len(ids) + len(ioIDs) + 1)
+			ids[proc] = id
+			name := fmt.Sprintf("%v %v", proc.Location, proc.CommandName(p.Scopes[proc]))
+			function(id, name, proc.Location.Path, proc.Location.Ln)
+		}
+		return append([]uint64{id}, stack(p.parents[proc])...)
+	}
+	sample := func(locations []uint64, values ...uint64) {
+		var s protoBuffer
+		s.packed(1, locations)
+		s.packed(2, values)
+		b.bytes(2, s)
+	}
+
+	for proc, pp := range p.Procs {
+		sample(stack(proc), pp.Execs, pp.Copies, 0, 0)
+	}
+	for site, cp := range p.Channels {
+		var locations []uint64
+		if site.Proc != nil {
+			locations = stack(site.Proc)
+		} else {
+			id, ok := ioIDs[site.IOIndex]
+			if !ok {
+				id = uint64(len(ids) + len(ioIDs) + 1)
+				ioIDs[site.IOIndex] = id
+				function(id, IOChannelName(site.IOIndex), "", 0)
+			}
+			locations = []uint64{id}
+		}
+		sample(locations, 0, 0, cp.Delivered, cp.Deferred)
+	}
+	b.uint(14, str("execs")) // Default sample type
+	for _, s := range table {
+		b.string(6, s)
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Minimal protocol buffer encoder
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protoBuffer) uint(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuffer) bytes(field int, x []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(x)))
+	*b = append(*b, x...)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p)
+}
@@ -0,0 +1,27 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// Channels that are created by the same process are counted together.
+func TestProfilerSites(t *testing.T) {
+	p := compileSource(t, "+s;(s<<s;+c;c->c;x<-c. s->s. s->s. s->s.)")
+	profiler := NewProfiler(p.Proc)
+	p.Trace = profiler
+	if err := p.Run(context.Background(), strings.NewReader(""), &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	counts := make([]uint64, 0)
+	for site, cp := range profiler.Channels {
+		if site.Proc != nil && site.Proc.Name == "c" {
+			counts = append(counts, cp.Delivered)
+		}
+	}
+	if len(counts) != 1 || counts[0] != 3 {
+		t.Errorf("deliveries on c by site %v, want [3]", counts)
+	}
+}
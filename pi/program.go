@@ -0,0 +1,271 @@
+// Package pi implements an interpreter for the PI language, a simple
+// programming language based on the Pi calculus.
+package pi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+)
+
+// Program is a compiled PI program.
+type Program struct {
+	Files    []string  // Loaded source files
+	Globals  []string  // Global names
+	Core     []*Proc   // Core language processes
+	Proc     []*Proc   // Optimized processes
+	Warnings ErrorList // Attach cycles that were skipped
+	Trace    Tracer    // Optional tracer for Run
+}
+
+// ReadFunc reads a source file.
+type ReadFunc func(path string) ([]byte, error)
+
+// Load reads and parses the given files and all files they attach. Returns the
+// processes of all files, the global names and the paths of the loaded files.
+// The names in the processes are not qualified (see Compile).
+func Load(files ...string) ([]Process, []string, []string, error) {
+	return Options{}.Load(files...)
+}
+
+// Load is like Load but finds attached files with the options.
+func (o Options) Load(files ...string) ([]Process, []string, []string, error) {
+	errs := ErrorList([]error{})
+	sources, err := loadFiles(o, files, MakeSet(), ioutil.ReadFile, &errs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	l := newLinker()
+	l.Link(sources, &errs)
+	if len(errs) != 0 {
+		return nil, nil, nil, errs
+	}
+	proc := make([]Process, 0)
+	for _, f := range sources {
+		proc = append(proc, f.proc...)
+	}
+	return proc, identNames(l.globals), filePaths(sources), nil
+}
+
+// Dependency is a file of a program with the files it attaches.
+type Dependency struct {
+	Path   string
+	Attach []string
+}
+
+// Dependencies reads the given files and all files they attach and returns the
+// files in load order (every file follows the files it attaches).
+func Dependencies(files ...string) ([]Dependency, error) {
+	return Options{}.Dependencies(files...)
+}
+
+// Dependencies is like Dependencies but finds attached files with the options.
+func (o Options) Dependencies(files ...string) ([]Dependency, error) {
+	errs := ErrorList([]error{})
+	sources, err := loadFiles(o, files, MakeSet(), ioutil.ReadFile, &errs)
+	if err != nil {
+		return nil, err
+	} else if len(errs) != 0 {
+		return nil, errs
+	}
+	deps := make([]Dependency, len(sources))
+	for i, f := range sources {
+		attach := make([]string, len(f.attach))
+		for j, a := range f.attach {
+			attach[j] = a.path
+		}
+		deps[i] = Dependency{f.path, attach}
+	}
+	return deps, nil
+}
+
+// Desugar the processes of all files in the scope of the global names. The
+// names that are visible in a file are given by its scope.
+func desugarGlobals(files []*sourceFile, global []Ident, err *ErrorList) []*Proc {
+	d := &desugarer{ioChannelOffset, make(map[string]int), err, nil, nil}
+	for _, v := range global {
+		d.bind(Loc{}, PINewRef, -1, v.Name, false)
+	}
+	body := make([]*Proc, 0)
+	for _, f := range files {
+		bound := make(map[string]int, len(f.scope))
+		for name, i := range f.scope {
+			bound[name] = ioChannelOffset + i
+		}
+		body = append(body, Desugar(f.proc, d.refOffset, bound, err)...)
+	}
+	if d.last == nil {
+		return body
+	}
+	d.last.Children = body
+	return d.first
+}
+
+// Read and parse the given files and all files they attach that are not yet
+// loaded. Returns the files in load order: every file follows the files it
+// attaches (depth first in the order of the #attach directives). Files are read
+// using read and attached files are found with opts. Parse errors and attached
+// files that cannot be read are added to errs; only files that are given
+// directly return an error. An attachment that closes a cycle is skipped (the
+// file is already being loaded) and is recorded as a warning of the file.
+func loadFiles(opts Options, files []string, loaded Set, read ReadFunc,
+	errs *ErrorList) ([]*sourceFile, error) {
+	l := &fileLoader{opts, loaded, read, errs, make([]*sourceFile, 0), nil, nil}
+	for _, file := range files {
+		path := opts.resolveAttach("", file)
+		key := opts.fileKey(path)
+		if loaded.Contains(key) {
+			continue
+		}
+		bytes, err := opts.readSource(read, path)
+		if err != nil {
+			return nil, err
+		}
+		l.load(path, key, bytes)
+	}
+	return l.files, nil
+}
+
+// A fileLoader loads files depth first.
+type fileLoader struct {
+	opts   Options
+	loaded Set
+	read   ReadFunc
+	errs   *ErrorList
+	files  []*sourceFile // Loaded files in load order
+	chain  []*sourceFile // Files that are being loaded
+	via    []attachment  // Attachments between the files in chain
+}
+
+func (l *fileLoader) load(path string, key string, bytes []byte) {
+	l.loaded.Add(key)
+	directives, offset, source := ParseDirectives(string(bytes), path)
+	f := parseSourceFile(l.opts, path, key, directives, l.errs)
+
+	// Load attached files first.
+	l.chain = append(l.chain, f)
+	for _, a := range f.attach {
+		if l.cycle(a) || l.loaded.Contains(a.key) {
+			continue
+		}
+		bytes, err := l.opts.readSource(l.read, a.path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				l.errs.Add(diagnostic(a.Span, CodeAttach, "attached file %v does not exist", a.file))
+			} else {
+				l.errs.Add(diagnostic(a.Span, CodeAttach, "cannot read attached file %v: %v", a.file, err))
+			}
+			continue
+		}
+		l.via = append(l.via, a)
+		l.load(a.path, a.key, bytes)
+		l.via = l.via[:len(l.via)-1]
+	}
+	l.chain = l.chain[:len(l.chain)-1]
+
+	// Add processes in this file.
+	tokens := Tokenize(source, Loc{path, offset + 1, 1})
+	tokens = ExpandMacros(tokens, f.macros, l.errs)
+	f.proc = Parse(tokens, l.errs)
+	l.files = append(l.files, f)
+}
+
+// Record an attachment that closes a cycle with the files that are being
+// loaded as a warning of the attaching file.
+func (l *fileLoader) cycle(a attachment) bool {
+	for i, f := range l.chain {
+		if f.key != a.key {
+			continue
+		}
+		names := make([]string, 0, len(l.chain)-i+1)
+		for _, f := range l.chain[i:] {
+			names = append(names, displayPath(f.path))
+		}
+		names = append(names, names[0])
+		d := &Diagnostic{SeverityWarning, RuleAttachCycle,
+			fmt.Sprintf("attach cycle: %v", strings.Join(names, " -> ")), a.Span, nil, nil}
+		for j, via := range l.via[i:] {
+			d.Relate(via.Span, "%v attaches %v", names[j], names[j+1])
+		}
+		from := l.chain[len(l.chain)-1]
+		from.warnings = append(from.warnings, d)
+		return true
+	}
+	return false
+}
+
+func filePaths(files []*sourceFile) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths
+}
+
+func identNames(idents []Ident) []string {
+	names := make([]string, len(idents))
+	for i, v := range idents {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// Compile loads, parses and optimizes the given files. Attached files are only
+// found relative to the attaching file (see Options).
+func Compile(files ...string) (*Program, error) {
+	return Options{}.Compile(files...)
+}
+
+// Compile is like Compile but finds attached files with the options.
+func (o Options) Compile(files ...string) (*Program, error) {
+	errs := ErrorList([]error{})
+	sources, err := loadFiles(o, files, MakeSet(), ioutil.ReadFile, &errs)
+	if err != nil {
+		return nil, err
+	}
+	l := newLinker()
+	l.Link(sources, &errs)
+	core := desugarGlobals(sources, l.globals, &errs)
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	// Optimize program.
+	optimized, err := Optimize(core)
+	if err != nil {
+		return nil, err
+	}
+	warnings := ErrorList{}
+	for _, f := range sources {
+		warnings = append(warnings, f.warnings...)
+	}
+	return &Program{filePaths(sources), identNames(l.globals), core, optimized, warnings, nil}, nil
+}
+
+// Start creates the initial state of the program.
+func (p *Program) Start() *Pi {
+	pi := &Pi{Trace: p.Trace}
+	pi.Initialize(p.Proc)
+	return pi
+}
+
+// Run executes the program until it terminates or the context is done.
+func (p *Program) Run(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+	return p.Start().Run(ctx, stdin, stdout)
+}
+
+// Scopes returns the reference names of all processes (see Scopes).
+func (p *Program) Scopes() map[*Proc][]string {
+	return Scopes(p.Proc)
+}
+
+// RunGoroutines executes the program using the goroutine runtime.
+func (p *Program) RunGoroutines(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+	pi := GoPi{}
+	pi.Initialize(p.Proc)
+	return pi.Run(ctx, stdin, stdout)
+}
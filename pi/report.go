@@ -0,0 +1,102 @@
+package pi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WriteBlockedReport writes a line for every blocked listener with its location
+// and the name of the channel it is waiting on.
+func WriteBlockedReport(w io.Writer, blocked []Node, scopes map[*Proc][]string) {
+	fmt.Fprintf(w, "%v blocked listeners:\n", len(blocked))
+	for _, node := range blocked {
+		p := node.Proc
+		name := "?"
+		if names := scopes[p]; p.Channel < len(names) {
+			name = names[p.Channel]
+		}
+		fmt.Fprintf(w, "%v: %v waits on %v\n", p.Location, p.CommandName(scopes[p]), name)
+	}
+}
+
+// ListenWatch is a tracer that records the channels on which processes listen
+// such that the listeners that are still waiting can be reported.
+type ListenWatch struct {
+	channels []*Channel
+	seen     Set
+	prune    int // Number of channels at which to remove channels without listeners
+}
+
+// Trace records listen events.
+func (w *ListenWatch) Trace(e Event) {
+	if e.Type != EventListen {
+		return
+	} else if w.seen == nil {
+		w.seen = MakeSet()
+	} else if w.seen.Contains(e.Channel) {
+		return
+	}
+	w.seen.Add(e.Channel)
+	w.channels = append(w.channels, e.Channel)
+	if len(w.channels) >= w.prune {
+		w.removeIdle()
+		w.prune = 2*len(w.channels) + 1024
+	}
+}
+
+// Remove channels without listeners (so they can be freed).
+func (w *ListenWatch) removeIdle() {
+	channels := w.channels[:0]
+	for _, c := range w.channels {
+		if len(c.Listeners) > 0 {
+			channels = append(channels, c)
+		} else {
+			w.seen.Remove(c)
+		}
+	}
+	for i := len(channels); i < len(w.channels); i++ {
+		w.channels[i] = nil
+	}
+	w.channels = channels
+}
+
+// Blocked returns the listeners that are still waiting, ordered by location.
+// This includes listeners for all messages (y<<x). When the queue and the ether
+// are empty these listeners are waiting forever.
+func (w *ListenWatch) Blocked() []Node {
+	blocked := make([]Node, 0)
+	for _, c := range w.channels {
+		blocked = append(blocked, c.Listeners...)
+	}
+	sort.SliceStable(blocked, func(i, j int) bool {
+		return locLess(blocked[i].Proc.Location, blocked[j].Proc.Location)
+	})
+	return blocked
+}
+
+// SendWatch is a tracer that records if a message was sent on a channel with
+// the given name.
+type SendWatch struct {
+	Name   string
+	Scopes map[*Proc][]string
+	Fired  bool
+}
+
+// Trace checks send events.
+func (w *SendWatch) Trace(e Event) {
+	if e.Type == EventSend && !w.Fired {
+		names := w.Scopes[e.Proc]
+		w.Fired = e.Proc.Channel < len(names) && names[e.Proc.Channel] == w.Name
+	}
+}
+
+// MultiTracer sends events to multiple tracers.
+type MultiTracer []Tracer
+
+// Trace sends the event to all tracers.
+func (ts MultiTracer) Trace(e Event) {
+	for _, t := range ts {
+		t.Trace(e)
+	}
+}
@@ -0,0 +1,24 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// Listeners for one message and for all messages are reported.
+func TestListenWatchBlocked(t *testing.T) {
+	p := compileSource(t, "+a,b;(x<-a;<>stdout__A. y<<b;<>stdout__B.)")
+	listen := &ListenWatch{}
+	p.Trace = listen
+	if err := p.Run(context.Background(), strings.NewReader(""), &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	WriteBlockedReport(&b, listen.Blocked(), p.Scopes())
+	want := "2 blocked listeners:\ntest.pi:1:7: x<-a waits on a\ntest.pi:1:25: y<<b waits on b\n"
+	if b.String() != want {
+		t.Errorf("report %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,412 @@
+package pi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Pi represents the state of a Pi program.
+type Pi struct {
+	Cycle    uint64
+	Steps    uint64 // Number of executed nodes
+	Channels uint64 // Number of created channels
+	Queue    []Node
+	Ether    []Message
+	Stdio    []*Channel
+	Trace    Tracer    // Optional event tracer
+	Sched    Scheduler // Optional scheduler (the default is FIFOScheduler)
+}
+
+// Channel holds channel and subscription information.
+type Channel struct {
+	IOIndex   int    // -1 or IO channel index
+	Listeners []Node // Current channel listeners
+	PrevCycle uint64 // Previous cycle in which a message was delivered
+	ID        uint64 // Number of the channel in order of creation (0 for IO)
+	Site      *Proc  // Process that created the channel (or nil)
+}
+
+// Node represents a process with a number of bound channels. This follows the
+// signalling network metaphor. A process is a pointer to the program AST.
+type Node struct {
+	Proc *Proc      // Process at which this node is paused
+	Refs []*Channel // Referenced channels
+}
+
+// Message represents a single message.
+type Message struct {
+	Channel *Channel
+	Content *Channel
+}
+
+// Schedule adds child processes to the queue with the provided references.
+func (pi *Pi) Schedule(proc []*Proc, refs []*Channel) {
+	// Make sure each process gets a copy of the references such that they can
+	// modify the references in place without interferring with other processes.
+	for i, p := range proc {
+		if i == 0 {
+			pi.Queue = append(pi.Queue, Node{p, refs})
+		} else {
+			pi.Queue = append(pi.Queue, Node{p, copyRefs(refs)})
+		}
+	}
+}
+
+// Initialize sets up the initial program state.
+func (pi *Pi) Initialize(proc []*Proc) {
+	// Create IO channels.
+	pi.Stdio = make([]*Channel, ioChannelOffset)
+	for i := 0; i < ioChannelOffset; i++ {
+		pi.Stdio[i] = &Channel{i, nil, 0, 0, nil}
+	}
+	pi.Schedule(proc, copyRefs(pi.Stdio))
+}
+
+// RunNextNode executes the next node in the process queue (the first node
+// unless a scheduler picks another one).
+func (pi *Pi) RunNextNode() {
+	if len(pi.Queue) == 0 {
+		return
+	}
+
+	// Remove the node chosen by the scheduler from the queue.
+	i := 0
+	if pi.Sched != nil {
+		i = pi.Sched.Next(pi.Queue)
+	}
+	node := pi.Queue[i]
+	pi.Steps++
+	if i == 0 {
+		pi.Queue = pi.Queue[1:]
+	} else {
+		pi.Queue = append(pi.Queue[:i], pi.Queue[i+1:]...)
+	}
+	pi.trace(Event{Type: EventExec, Proc: node.Proc})
+
+	switch node.Proc.Command {
+	case PINewRef:
+		assert(len(node.Refs) == node.Proc.Channel)
+		pi.Channels++
+		channel := &Channel{-1, nil, 0, pi.Channels, node.Proc}
+		refs := append(node.Refs, channel)
+		pi.Schedule(node.Proc.Children, refs)
+		pi.trace(Event{Type: EventNew, Proc: node.Proc, Channel: channel})
+
+	case PIDeref:
+		refs := deleteRef(node.Refs, node.Proc.Channel)
+		pi.Schedule(node.Proc.Children, refs)
+
+	case PISubsOne:
+		fallthrough
+	case PISubsAll:
+		channel := node.Refs[node.Proc.Channel]
+		channel.Listeners = append(channel.Listeners, node)
+		pi.trace(Event{Type: EventListen, Proc: node.Proc, Channel: channel})
+
+	case PISend:
+		channel := node.Refs[node.Proc.Channel]
+		message := node.Refs[node.Proc.Message]
+		pi.Ether = append(pi.Ether, Message{channel, message})
+		pi.Schedule(node.Proc.Children, node.Refs)
+		pi.trace(Event{Type: EventSend, Proc: node.Proc, Channel: channel, Content: message})
+
+		// Messages to the debug channel are handled immediately. This is practical
+		// because if we wait the listeners may change.
+		if channel.IOIndex == miscIOChannels["DEBUG"] {
+			message.PrintDebugInfo()
+		}
+	}
+}
+
+// Run executes nodes and delivers messages until the queue and the ether are
+// empty or until the context is done.
+func (pi *Pi) Run(ctx context.Context, input io.Reader, output io.Writer) error {
+	return pi.RunLimits(ctx, input, output, Limits{})
+}
+
+// Limits stop a program that runs too long or creates too many channels. Zero
+// values are unlimited.
+type Limits struct {
+	MaxCycles   uint64        // Number of delivery cycles
+	MaxSteps    uint64        // Number of executed nodes
+	MaxChannels uint64        // Number of created channels
+	Timeout     time.Duration // Wall-clock time
+}
+
+// LimitError is returned when a program is stopped by a limit. It contains a
+// summary of the state.
+type LimitError struct {
+	Limit     string // Name of the limit (max_cycles, max_steps, max_channels or timeout)
+	Value     string
+	Cycle     uint64
+	Steps     uint64
+	Channels  uint64
+	Queue     int
+	Ether     int
+	Listeners int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("stopped by %v=%v at cycle %v (%v steps, %v channels created, "+
+		"%v queued nodes, %v messages in the ether, %v listeners)", e.Limit, e.Value,
+		e.Cycle, e.Steps, e.Channels, e.Queue, e.Ether, e.Listeners)
+}
+
+// RunLimits is like Run but stops with a *LimitError when a limit is reached.
+func (pi *Pi) RunLimits(ctx context.Context, input io.Reader, output io.Writer,
+	limits Limits) error {
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	check := func() error {
+		switch {
+		case limits.MaxCycles > 0 && pi.Cycle >= limits.MaxCycles:
+			return pi.limitError("max_cycles", limits.MaxCycles)
+		case limits.MaxSteps > 0 && pi.Steps >= limits.MaxSteps && len(pi.Queue) > 0:
+			return pi.limitError("max_steps", limits.MaxSteps)
+		case limits.MaxChannels > 0 && pi.Channels >= limits.MaxChannels:
+			return pi.limitError("max_channels", limits.MaxChannels)
+		case ctx.Err() == context.DeadlineExceeded && limits.Timeout > 0:
+			return pi.limitError("timeout", limits.Timeout)
+		}
+		return ctx.Err()
+	}
+	for len(pi.Queue)+len(pi.Ether) > 0 {
+		if err := check(); err != nil {
+			return err
+		}
+		for len(pi.Queue) > 0 {
+			// A single cycle can run for a long time. The limits are checked
+			// before a node runs, so a program that ends on the last allowed
+			// step is not stopped.
+			if pi.Steps%1024 == 0 || limits.MaxSteps > 0 || limits.MaxChannels > 0 {
+				if err := check(); err != nil {
+					return err
+				}
+			}
+			pi.RunNextNode()
+		}
+		pi.DeliverMessages(input, output)
+	}
+	return nil
+}
+
+func (pi *Pi) limitError(limit string, value interface{}) *LimitError {
+	listeners := 0
+	for _, c := range pi.reachable() {
+		listeners += len(c.Listeners)
+	}
+	return &LimitError{limit, fmt.Sprint(value), pi.Cycle, pi.Steps, pi.Channels,
+		len(pi.Queue), len(pi.Ether), listeners}
+}
+
+// Return the channels that can still receive a message: the IO channels, the
+// channels that are referenced by the queue and the ether, and the channels
+// that are referenced by listeners of these channels.
+func (pi *Pi) reachable() []*Channel {
+	seen := MakeSet()
+	channels := make([]*Channel, 0)
+	visit := func(c *Channel) {
+		if c != nil && !seen.Contains(c) {
+			seen.Add(c)
+			channels = append(channels, c)
+		}
+	}
+	for _, c := range pi.Stdio {
+		visit(c)
+	}
+	for _, n := range pi.Queue {
+		for _, c := range n.Refs {
+			visit(c)
+		}
+	}
+	for _, m := range pi.Ether {
+		visit(m.Channel)
+		visit(m.Content)
+	}
+	for i := 0; i < len(channels); i++ {
+		for _, n := range channels[i].Listeners {
+			for _, c := range n.Refs {
+				visit(c)
+			}
+		}
+	}
+	return channels
+}
+
+// DeliverMessages delivers up to one message per channel from the ether.
+func (pi *Pi) DeliverMessages(input io.Reader, output io.Writer) {
+	pi.Cycle++
+	messages := pi.Ether
+	pi.Ether = pi.Ether[0:0]
+
+	if pi.Sched != nil {
+		pi.Sched.Order(messages)
+	}
+
+	for _, m := range messages {
+		// Check if we can send a message on this channel in the current cycle. We
+		// send only one message per channel per cycle!
+		if m.Channel.PrevCycle < pi.Cycle {
+			m.Channel.PrevCycle = pi.Cycle
+		} else {
+			// Put message back into the ether.
+			pi.Ether = append(pi.Ether, m)
+			pi.trace(Event{Type: EventDefer, Channel: m.Channel, Content: m.Content})
+			continue
+		}
+		if pi.Sched != nil {
+			switch pi.Sched.Fate(m) {
+			case Delay:
+				pi.Ether = append(pi.Ether, m)
+				pi.trace(Event{Type: EventDefer, Channel: m.Channel, Content: m.Content})
+				continue
+			case Drop:
+				pi.trace(Event{Type: EventDrop, Channel: m.Channel, Content: m.Content})
+				continue
+			}
+		}
+
+		listeners := m.Channel.Listeners
+		pi.trace(Event{Type: EventDeliver, Channel: m.Channel, Content: m.Content,
+			Listeners: len(listeners)})
+		m.Channel.Listeners = m.Channel.Listeners[0:0]
+		for _, node := range listeners {
+			assert(len(node.Refs) == node.Proc.Message)
+
+			// Copy references of a PISubsAll subscription and renew subscription.
+			refs := node.Refs
+			if node.Proc.Command == PISubsAll {
+				refs = copyRefs(node.Refs)
+				m.Channel.Listeners = append(m.Channel.Listeners, node)
+				pi.trace(Event{Type: EventCopy, Proc: node.Proc, Channel: m.Channel})
+			}
+
+			// Append message content to references and queue child processes.
+			refs = append(refs, m.Content)
+			pi.Schedule(node.Proc.Children, refs)
+		}
+
+		// Clear part of the listeners that we did not overwrite (for GC).
+		for i := len(m.Channel.Listeners); i < len(listeners); i++ {
+			listeners[i] = Node{}
+		}
+
+		// Handle IO messages. Note that the way we iterate and overwrite the ether
+		// buffer at the same time is only ok as long as this function returns at
+		// most one message.
+		if m.Channel.IOIndex != -1 {
+			ioMessages := pi.handleStdioMessage(input, output, m)
+			pi.Ether = append(pi.Ether, ioMessages...)
+		}
+	}
+
+	// Clear part of the ether that we did not overwrite (for GC).
+	for i := len(pi.Ether); i < len(messages); i++ {
+		messages[i] = Message{}
+	}
+}
+
+func (pi *Pi) handleStdioMessage(in io.Reader, out io.Writer, m Message) []Message {
+	// + Standard input read trigger.
+	// + Standard output byte trigger.
+	// + Debug info channel.
+	id := m.Channel.IOIndex
+	if id == miscIOChannels["stdin_read"] {
+		// Wait for next byte (or EOF)
+		buf := make([]byte, 1)
+		if _, err := in.Read(buf); err == nil {
+			// Send byte read trigger.
+			pi.trace(Event{Type: EventRead, Byte: int(buf[0])})
+			byteReadChannel := pi.Stdio[buf[0]]
+			return []Message{Message{byteReadChannel, m.Content}}
+		} else if err == io.EOF {
+			// Send EOF trigger.
+			pi.trace(Event{Type: EventRead, Byte: -1})
+			eofChannel := pi.Stdio[miscIOChannels["stdin_EOF"]]
+			return []Message{Message{eofChannel, m.Content}}
+		}
+	} else if stdoutOffset <= id && id < stdoutOffset+256 {
+		// Write byte to stdout and send acknowledgement message.
+		b := byte(id - stdoutOffset)
+		out.Write([]byte{b})
+		pi.trace(Event{Type: EventWrite, Byte: int(b)})
+		return []Message{Message{m.Content, m.Content}}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the state. The tracer and the scheduler are
+// shared with the copy.
+func (pi *Pi) Clone() *Pi {
+	channels := make(map[*Channel]*Channel)
+	var channel func(c *Channel) *Channel
+	node := func(n Node) Node {
+		refs := make([]*Channel, len(n.Refs))
+		for i, c := range n.Refs {
+			refs[i] = channel(c)
+		}
+		return Node{n.Proc, refs}
+	}
+	channel = func(c *Channel) *Channel {
+		if c == nil {
+			return nil
+		} else if dup, ok := channels[c]; ok {
+			return dup
+		}
+		dup := &Channel{c.IOIndex, nil, c.PrevCycle, c.ID, c.Site}
+		channels[c] = dup
+		for _, n := range c.Listeners {
+			dup.Listeners = append(dup.Listeners, node(n))
+		}
+		return dup
+	}
+
+	clone := &Pi{Cycle: pi.Cycle, Steps: pi.Steps, Channels: pi.Channels, Trace: pi.Trace,
+		Sched: pi.Sched}
+	for _, n := range pi.Queue {
+		clone.Queue = append(clone.Queue, node(n))
+	}
+	for _, m := range pi.Ether {
+		clone.Ether = append(clone.Ether, Message{channel(m.Channel), channel(m.Content)})
+	}
+	for _, c := range pi.Stdio {
+		clone.Stdio = append(clone.Stdio, channel(c))
+	}
+	return clone
+}
+
+func (pi *Pi) trace(e Event) {
+	if pi.Trace != nil {
+		e.Cycle = pi.Cycle
+		pi.Trace.Trace(e)
+	}
+}
+
+// PrintDebugInfo prints the listeners.
+func (c *Channel) PrintDebugInfo() {
+	println()
+	println("--- DEBUG SECTION ---")
+	fmt.Printf("channel address: %p\n", c)
+	for _, node := range c.Listeners {
+		fmt.Printf("+ %v\n", node.Proc.Location)
+	}
+	println("---------------------")
+}
+
+func copyRefs(src []*Channel) []*Channel {
+	return append(src[:0:0], src...)
+}
+
+func deleteRef(src []*Channel, i int) []*Channel {
+	// See https://github.com/golang/go/wiki/SliceTricks
+	if i < len(src)-1 {
+		copy(src[i:], src[i+1:])
+	}
+	src[len(src)-1] = nil
+	return src[:len(src)-1]
+}
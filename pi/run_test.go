@@ -0,0 +1,28 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// A program that ends on the last allowed step is not stopped by the limit.
+func TestRunLimitsSteps(t *testing.T) {
+	p := compileSource(t, "+a;a->a;<>stdout__A.")
+	state := p.Start()
+	if err := state.Run(context.Background(), strings.NewReader(""), &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	steps := state.Steps
+	for _, max := range []uint64{steps - 1, steps} {
+		var out bytes.Buffer
+		err := p.Start().RunLimits(context.Background(), strings.NewReader(""), &out,
+			Limits{MaxSteps: max})
+		if _, ok := err.(*LimitError); ok != (max < steps) {
+			t.Errorf("max_steps=%v of %v: error %v", max, steps, err)
+		} else if max == steps && out.String() != "A" {
+			t.Errorf("max_steps=%v: output %q, want %q", max, out.String(), "A")
+		}
+	}
+}
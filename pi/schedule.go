@@ -0,0 +1,160 @@
+package pi
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Scheduler decides the execution order of a Pi state. Schedulers must keep
+// the order of messages on the same channel (a process can rely on this order).
+type Scheduler interface {
+	// Next returns the index of the next node in a non-empty queue.
+	Next(queue []Node) int
+	// Order reorders the ether before messages are delivered.
+	Order(ether []Message)
+	// Fate decides what happens to a message that can be delivered.
+	Fate(m Message) Fate
+}
+
+// Fate of a message
+type Fate uint8
+
+// Fates
+const (
+	Deliver Fate = iota // Deliver the message
+	Delay               // Keep the message in the ether until the next cycle
+	Drop                // Remove the message
+)
+
+// FIFOScheduler runs nodes in the order in which they are scheduled and
+// delivers messages in the order in which they are sent. This is the default.
+type FIFOScheduler struct{}
+
+// Next returns the first node.
+func (FIFOScheduler) Next(queue []Node) int {
+	return 0
+}
+
+// Order keeps the order of the ether.
+func (FIFOScheduler) Order(ether []Message) {}
+
+// Fate always delivers.
+func (FIFOScheduler) Fate(m Message) Fate {
+	return Deliver
+}
+
+// RandomScheduler runs nodes and delivers the ether in a random order. Which
+// message is delivered first on a channel is randomized by the order in which
+// nodes run.
+type RandomScheduler struct {
+	Rand *rand.Rand
+}
+
+// NewRandomScheduler creates a random scheduler with the given seed.
+func NewRandomScheduler(seed int64) *RandomScheduler {
+	return &RandomScheduler{rand.New(rand.NewSource(seed))}
+}
+
+// Next returns a random node.
+func (s *RandomScheduler) Next(queue []Node) int {
+	return s.Rand.Intn(len(queue))
+}
+
+// Order shuffles the ether.
+func (s *RandomScheduler) Order(ether []Message) {
+	reorder(ether, s.Rand.Perm(len(ether)))
+}
+
+// Fate always delivers.
+func (s *RandomScheduler) Fate(m Message) Fate {
+	return Deliver
+}
+
+// AdversarialScheduler runs the most recently scheduled node first and
+// delivers the most recent messages first. This postpones older processes as
+// long as possible and reverses the order of independent messages.
+type AdversarialScheduler struct{}
+
+// Next returns the last node.
+func (AdversarialScheduler) Next(queue []Node) int {
+	return len(queue) - 1
+}
+
+// Order reverses the ether.
+func (AdversarialScheduler) Order(ether []Message) {
+	perm := make([]int, len(ether))
+	for i := range perm {
+		perm[i] = len(ether) - 1 - i
+	}
+	reorder(ether, perm)
+}
+
+// Fate always delivers.
+func (AdversarialScheduler) Fate(m Message) Fate {
+	return Deliver
+}
+
+// LossyScheduler delays and drops messages at random and otherwise follows
+// another scheduler.
+type LossyScheduler struct {
+	Scheduler
+	Rand  *rand.Rand
+	Delay float64 // Probability that a message is delayed to the next cycle
+	Drop  float64 // Probability that a message is dropped
+}
+
+// Fate delays or drops a message with the configured probabilities.
+func (s *LossyScheduler) Fate(m Message) Fate {
+	if f := s.Scheduler.Fate(m); f != Deliver {
+		return f
+	}
+	switch p := s.Rand.Float64(); {
+	case p < s.Drop:
+		return Drop
+	case p < s.Drop+s.Delay:
+		return Delay
+	}
+	return Deliver
+}
+
+// NewScheduler creates a scheduler by name (fifo, random or adversarial) that
+// delays and drops messages with the given probabilities. The seed is used for
+// all random decisions (the random order and the fate of messages use distinct
+// sources derived from it).
+func NewScheduler(name string, seed int64, delay float64, drop float64) (Scheduler, error) {
+	var s Scheduler
+	switch name {
+	case "fifo":
+		s = FIFOScheduler{}
+	case "random":
+		s = NewRandomScheduler(seed)
+	case "adversarial":
+		s = AdversarialScheduler{}
+	default:
+		return nil, fmt.Errorf("unknown scheduler %v", name)
+	}
+	if delay < 0 || drop < 0 || delay+drop >= 1 {
+		return nil, fmt.Errorf("invalid delay and drop probabilities")
+	}
+	if delay > 0 || drop > 0 {
+		lossySeed := int64(uint64(seed) ^ 0x9e3779b97f4a7c15)
+		s = &LossyScheduler{s, rand.New(rand.NewSource(lossySeed)), delay, drop}
+	}
+	return s, nil
+}
+
+// Reorder the messages in the positions given by a permutation but keep the
+// order of messages on the same channel.
+func reorder(ether []Message, perm []int) {
+	pending := make(map[*Channel][]Message)
+	for _, m := range ether {
+		pending[m.Channel] = append(pending[m.Channel], m)
+	}
+	channels := make([]*Channel, len(ether))
+	for i, j := range perm {
+		channels[i] = ether[j].Channel
+	}
+	for i, c := range channels {
+		ether[i], pending[c] = pending[c][0], pending[c][1:]
+	}
+}
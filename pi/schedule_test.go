@@ -0,0 +1,22 @@
+package pi
+
+import "testing"
+
+// The random order and the fate of messages do not use the same random numbers.
+func TestNewSchedulerSeeds(t *testing.T) {
+	s, err := NewScheduler("random", 1, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lossy := s.(*LossyScheduler)
+	order := lossy.Scheduler.(*RandomScheduler).Rand
+	same := 0
+	for i := 0; i < 64; i++ {
+		if order.Int63() == lossy.Rand.Int63() {
+			same++
+		}
+	}
+	if same > 0 {
+		t.Errorf("%v of 64 random numbers are the same", same)
+	}
+}
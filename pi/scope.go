@@ -0,0 +1,36 @@
+package pi
+
+import "fmt"
+
+// Scopes maps every process to the names of its references, in order of the
+// reference indices. IO channels are named after the channel and other
+// references after their source name (or the location of the process that bound
+// them if the name is unknown).
+func Scopes(proc []*Proc) map[*Proc][]string {
+	scopes := make(map[*Proc][]string)
+	names := make([]string, ioChannelOffset)
+	for i := range names {
+		names[i] = IOChannelName(i)
+	}
+	scope(proc, names, scopes)
+	return scopes
+}
+
+// Add the scopes of processes that start with the given names to scopes.
+func scope(proc []*Proc, names []string, scopes map[*Proc][]string) {
+	for _, p := range proc {
+		scopes[p] = names
+		pNames := append(names[:0:0], names...)
+		switch p.Command {
+		case PINewRef, PISubsOne, PISubsAll:
+			if len(p.Name) > 0 {
+				pNames = append(pNames, p.Name)
+			} else {
+				pNames = append(pNames, fmt.Sprintf("(%v)", p.Location))
+			}
+		case PIDeref:
+			pNames = append(pNames[:p.Channel], pNames[p.Channel+1:]...)
+		}
+		scope(p.Children, pNames, scopes)
+	}
+}
@@ -0,0 +1,202 @@
+package pi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Session is a live PI state to which processes are added incrementally. Global
+// channels are created once and are available to all subsequent processes. The
+// exports of loaded modules are available qualified by the module name.
+type Session struct {
+	Pi      Pi
+	Globals []string   // Global names in order of definition
+	Origins []string   // File or REPL in which each global was declared
+	Files   []string   // Loaded files in order
+	Refs    []*Channel // IO channels followed by the global channels
+
+	bound  map[string]int
+	loaded Set
+	linker *linker
+	macros map[string]*Macro
+	scopes map[*Proc][]string
+	opts   Options
+}
+
+// NewSession creates an empty session.
+func NewSession() *Session {
+	return Options{}.NewSession()
+}
+
+// NewSession creates an empty session that finds attached files with the
+// options.
+func (o Options) NewSession() *Session {
+	s := &Session{
+		opts:   o,
+		bound:  make(map[string]int),
+		loaded: MakeSet(),
+		linker: newLinker(),
+		macros: make(map[string]*Macro),
+		scopes: make(map[*Proc][]string),
+	}
+	s.Pi.Initialize(nil)
+	s.Refs = copyRefs(s.Pi.Stdio)
+	return s
+}
+
+// Define creates a new global channel. Defining an existing name is a no-op.
+func (s *Session) Define(name string, origin string) {
+	if _, exists := s.bound[name]; exists {
+		return
+	}
+	s.linker.flat[name] = s.linker.add(Ident{Name: name})
+	s.addGlobals(func(Ident) string { return origin })
+}
+
+// Create channels for the globals of the linker that do not have one.
+func (s *Session) addGlobals(origin func(v Ident) string) {
+	for _, v := range s.linker.globals[len(s.Globals):] {
+		s.Pi.Channels++
+		s.Refs = append(s.Refs, &Channel{-1, nil, 0, s.Pi.Channels, nil})
+		s.Globals = append(s.Globals, v.Name)
+		s.Origins = append(s.Origins, origin(v))
+	}
+	for name, i := range s.linker.flat {
+		s.bound[name] = ioChannelOffset + i
+	}
+}
+
+// Exec adds the processes in the given source to the queue. The source may
+// start with directives. Macros that are defined with #define remain available
+// in later sources.
+func (s *Session) Exec(source string, start Loc) error {
+	directives, offset, source := ParseDirectives(source, start.Path)
+	errs := ErrorList([]error{})
+	attach := make([]string, 0)
+	for _, d := range directives {
+		checkDirective(d, &errs)
+		switch d.Key {
+		case "global":
+			s.Define(d.Value, fmt.Sprintf("%v:%v", filepath.Base(start.Path), start.Ln))
+		case "attach":
+			attach = append(attach, d.Value)
+		case "define":
+			if m := parseMacro(d, &errs); m != nil {
+				s.macros[m.Name.Name] = m
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	for _, value := range attach {
+		file, alias := splitAttach(value)
+		if err := s.Load(file); err != nil {
+			return err
+		}
+		// Make the exports available under the alias.
+		f, ok := s.linker.files[s.opts.fileKey(s.opts.resolveAttach("", file))]
+		if len(alias) == 0 || !ok {
+			continue
+		} else if len(f.module.Name) == 0 {
+			return fmt.Errorf("%v is not a module", file)
+		}
+		s.bindModule(alias, f)
+	}
+	start.Ln += offset
+	proc := Parse(ExpandMacros(Tokenize(source, start), s.macros, &errs), &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	return s.schedule(proc, s.bound)
+}
+
+// Load adds the processes in the given files (and the files they attach) to the
+// queue. Files that were loaded before are skipped.
+func (s *Session) Load(files ...string) error {
+	errs := ErrorList([]error{})
+	sources, err := loadFiles(s.opts, files, s.loaded, ioutil.ReadFile, &errs)
+	if err != nil {
+		return err
+	}
+	s.linker.Link(sources, &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	s.addGlobals(func(v Ident) string { return displayPath(v.Start.Path) })
+	for _, f := range sources {
+		if len(f.module.Name) > 0 {
+			s.bindModule(f.module.Name, f)
+		}
+		s.Files = append(s.Files, f.path)
+	}
+	for _, f := range sources {
+		bound := make(map[string]int, len(f.scope))
+		for name, i := range f.scope {
+			bound[name] = ioChannelOffset + i
+		}
+		if err := s.schedule(f.proc, bound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind the exports of a module qualified by the given name.
+func (s *Session) bindModule(qualifier string, f *sourceFile) {
+	for _, v := range f.exports {
+		s.bound[qualifier+sQualifier+v.Name] = ioChannelOffset + f.names[v.Name]
+	}
+}
+
+// Desugar, optimize and schedule the given processes with the given bound
+// global names.
+func (s *Session) schedule(processes []Process, bound map[string]int) error {
+	if len(processes) == 0 {
+		return nil
+	}
+	errs := ErrorList([]error{})
+	core := Desugar(processes, len(s.Refs), bound, &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	proc, err := OptimizeScope(core, len(s.Refs))
+	if err != nil {
+		return err
+	}
+	s.Pi.Schedule(proc, copyRefs(s.Refs))
+
+	// Record reference names.
+	names := make([]string, len(s.Refs))
+	for i := range names {
+		names[i] = s.ChannelName(s.Refs[i])
+	}
+	scope(proc, names, s.scopes)
+	return nil
+}
+
+// CommandName returns the command of a process with the names of references.
+func (s *Session) CommandName(p *Proc) string {
+	return p.CommandName(s.scopes[p])
+}
+
+// Run executes nodes and delivers messages until the state is quiescent.
+func (s *Session) Run(input io.Reader, output io.Writer) {
+	s.Pi.Run(context.Background(), input, output)
+}
+
+// ChannelName returns the IO or global name of a channel, or its address.
+func (s *Session) ChannelName(c *Channel) string {
+	if c.IOIndex != -1 {
+		return IOChannelName(c.IOIndex)
+	}
+	for i, r := range s.Refs[ioChannelOffset:] {
+		if r == c {
+			return s.Globals[i]
+		}
+	}
+	return fmt.Sprintf("%p", c)
+}
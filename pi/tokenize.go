@@ -0,0 +1,222 @@
+package pi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind is the type of a token.
+type TokenKind uint8
+
+// Token kinds
+const (
+	TokenInvalid       TokenKind = iota
+	TokenEOF                     // End of the source
+	TokenName                    // x
+	TokenComment                 // !...
+	TokenComma                   // ,
+	TokenSemicolon               // ;
+	TokenPeriod                  // .
+	TokenParOpen                 // (
+	TokenParClose                // )
+	TokenNew                     // +
+	TokenDeref                   // ~ (core format only)
+	TokenShadow                  // ^ (core format only)
+	TokenReceive                 // <-
+	TokenReceiveAll              // <<
+	TokenSend                    // ->
+	TokenTrigger                 // <>
+	TokenForward                 // >>
+	TokenTunnelSend              // >->
+	TokenTunnelReceive           // <-<
+	TokenTunnelOne               // <<-
+	TokenTunnelAll               // <<<
+)
+
+// Operators ordered such that longer operators are matched first.
+var operators = []struct {
+	Text string
+	Kind TokenKind
+}{
+	{">->", TokenTunnelSend},
+	{"<-<", TokenTunnelReceive},
+	{"<<-", TokenTunnelOne},
+	{"<<<", TokenTunnelAll},
+	{"<-", TokenReceive},
+	{"<<", TokenReceiveAll},
+	{"->", TokenSend},
+	{"<>", TokenTrigger},
+	{">>", TokenForward},
+	{sComment, TokenComment},
+	{",", TokenComma},
+	{sSemicolon, TokenSemicolon},
+	{sPeriod, TokenPeriod},
+	{sParOpen, TokenParOpen},
+	{sParClose, TokenParClose},
+	{"+", TokenNew},
+	{"~", TokenDeref},
+	{"^", TokenShadow},
+}
+
+// Token is a lexical element of PI source code.
+type Token struct {
+	Span
+	Kind    TokenKind
+	Content string
+}
+
+func (t Token) String() string {
+	if t.Kind == TokenEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("\"%v\"", t.Content)
+}
+
+// Tokenize splits PI source code into tokens. The first line of the source is
+// line start.Ln in start.Path. Whitespace is skipped, but comments are kept.
+func Tokenize(source string, start Loc) []Token {
+	return tokenize(source, start, isNameChar)
+}
+
+// Tokenize the core format, in which names may contain @ (see isCoreNameChar).
+func tokenizeCore(source string, start Loc) []Token {
+	return tokenize(source, start, isCoreNameChar)
+}
+
+func tokenize(source string, start Loc, nameChar func(byte) bool) []Token {
+	tokens := make([]Token, 0)
+	for ln, line := range strings.Split(source, "\n") {
+		loc := func(col int) Loc {
+			return Loc{start.Path, start.Ln + ln, col + 1}
+		}
+		for col := 0; col < len(line); {
+			kind, n := scanToken(line[col:], nameChar)
+			if kind != TokenInvalid || n > 0 {
+				tokens = append(tokens, Token{Span{loc(col), loc(col + n)}, kind, line[col : col+n]})
+			}
+			col += n
+			if n == 0 {
+				col++
+			}
+		}
+	}
+	return tokens
+}
+
+// Return the kind and the length of the token at the start of s. Whitespace has
+// kind TokenInvalid and length 0.
+func scanToken(s string, nameChar func(byte) bool) (TokenKind, int) {
+	if isSpace(s[0]) {
+		return TokenInvalid, 0
+	}
+	// Names may be qualified by a module (nat::add).
+	n := 0
+	for n < len(s) {
+		if nameChar(s[n]) {
+			n++
+		} else if q := len(sQualifier); n > 0 && strings.HasPrefix(s[n:], sQualifier) &&
+			n+q < len(s) && nameChar(s[n+q]) {
+			n += q
+		} else {
+			break
+		}
+	}
+	if n > 0 {
+		return TokenName, n
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(s, op.Text) {
+			if op.Kind == TokenComment {
+				return TokenComment, len(s)
+			}
+			return op.Kind, len(op.Text)
+		}
+	}
+	return TokenInvalid, 1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// Names that are generated by the desugarer and by macro expansion contain @,
+// so that they cannot capture names in the source.
+func isCoreNameChar(c byte) bool {
+	return isNameChar(c) || c == '@'
+}
+
+// Directive is a pre-processing directive (#key: value). The span is the span
+// of the value.
+type Directive struct {
+	Span
+	Key, Value string
+}
+
+// ExtractDirectives removes directives appearing at the beginning of the given
+// source. Directives can only occur before the PI script and do not depend on
+// each other. Comments and empty lines between directives are allowed.
+func ExtractDirectives(source string) ([]string, []string, int, string) {
+	directives, offset, source := ParseDirectives(source, "")
+	attach, global := make([]string, 0), make([]string, 0)
+	for _, d := range directives {
+		switch d.Key {
+		case "attach":
+			attach = append(attach, d.Value)
+		case "global":
+			global = append(global, d.Value)
+		}
+	}
+	return attach, global, offset, source
+}
+
+// Remove a comment from the value of a directive. A ! in a string literal does
+// not start a comment.
+func stripComment(value string) string {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quoted:
+			i++
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == sComment[0] && !quoted:
+			return value[:i]
+		}
+	}
+	return value
+}
+
+// ParseDirectives is like ExtractDirectives but returns all directives with
+// their location in the file at path.
+func ParseDirectives(source string, path string) ([]Directive, int, string) {
+	lines := strings.Split(source, "\n")
+	directives := make([]Directive, 0)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		m := directiveRE.FindStringSubmatch(trimmed)
+		if len(m) > 0 {
+			// Find the value in the line.
+			value := strings.TrimSpace(stripComment(m[2]))
+			col := strings.Index(line, ":") + 1
+			col += strings.Index(line[col:], value)
+			span := Span{Loc{path, i + 1, col + 1}, Loc{path, i + 1, col + len(value) + 1}}
+			directives = append(directives, Directive{span, m[1], value})
+		} else if strings.HasPrefix(trimmed, "#") {
+			// A directive without a key has an empty key (see checkDirective).
+			col := strings.Index(line, "#")
+			span := Span{Loc{path, i + 1, col + 1}, Loc{path, i + 1, col + len(trimmed) + 1}}
+			directives = append(directives, Directive{span, "", trimmed})
+		} else if len(trimmed) == 0 || trimmed[0:1] == sComment {
+			// Skip empty lines or comments.
+			continue
+		} else {
+			// End of directives; return result.
+			return directives, i, strings.Join(lines[i:], "\n")
+		}
+	}
+	return directives, len(lines), ""
+}
@@ -0,0 +1,103 @@
+package pi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Event types
+const (
+	EventExec    = "exec"    // Node executed
+	EventNew     = "new"     // Channel created by a node
+	EventCopy    = "copy"    // Listener of all messages copied for a message
+	EventListen  = "listen"  // Listener registered
+	EventSend    = "send"    // Message put into the ether
+	EventDeliver = "deliver" // Message delivered to the channel listeners
+	EventDefer   = "defer"   // Message deferred to the next cycle
+	EventDrop    = "drop"    // Message dropped by the scheduler
+	EventRead    = "read"    // Byte read from the standard input (-1 for EOF)
+	EventWrite   = "write"   // Byte written to the standard output
+)
+
+// Event is a single runtime event. Fields that do not apply are zero.
+type Event struct {
+	Type      string
+	Cycle     uint64
+	Proc      *Proc    // Executed process or listener
+	Channel   *Channel // Channel of the message or listener
+	Content   *Channel // Content of the message
+	Listeners int      // Number of listeners a message is delivered to
+	Byte      int      // IO byte
+}
+
+// Tracer receives runtime events.
+type Tracer interface {
+	Trace(e Event)
+}
+
+// JSONTracer writes one JSON object per event. Channels are identified by their
+// IO name or by their number in order of creation. Commands are written
+// with source names if Scopes is set.
+type JSONTracer struct {
+	Scopes map[*Proc][]string
+
+	enc *json.Encoder
+	err error
+}
+
+type jsonEvent struct {
+	Type      string `json:"type"`
+	Cycle     uint64 `json:"cycle"`
+	Loc       string `json:"loc,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Listeners *int   `json:"listeners,omitempty"`
+	Byte      *int   `json:"byte,omitempty"`
+}
+
+// NewJSONTracer creates a tracer that writes to out.
+func NewJSONTracer(out io.Writer) *JSONTracer {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	return &JSONTracer{nil, enc, nil}
+}
+
+// Trace writes the event.
+func (t *JSONTracer) Trace(e Event) {
+	if t.err != nil {
+		return
+	}
+	je := jsonEvent{
+		Type:    e.Type,
+		Cycle:   e.Cycle,
+		Channel: t.channelID(e.Channel),
+		Content: t.channelID(e.Content),
+	}
+	if e.Proc != nil {
+		je.Loc = e.Proc.Location.String()
+		je.Command = e.Proc.CommandName(t.Scopes[e.Proc])
+	}
+	if e.Type == EventDeliver {
+		je.Listeners = &e.Listeners
+	}
+	if e.Type == EventRead || e.Type == EventWrite {
+		je.Byte = &e.Byte
+	}
+	t.err = t.enc.Encode(je)
+}
+
+// Err returns the first write error.
+func (t *JSONTracer) Err() error {
+	return t.err
+}
+
+func (t *JSONTracer) channelID(c *Channel) string {
+	if c == nil {
+		return ""
+	} else if c.IOIndex != -1 {
+		return IOChannelName(c.IOIndex)
+	}
+	return fmt.Sprintf("#%v", c.ID)
+}
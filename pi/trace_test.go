@@ -0,0 +1,38 @@
+package pi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Channels are numbered in order of creation.
+func TestJSONTracerChannels(t *testing.T) {
+	p := compileSource(t, "+a;+b;a->b. +c;<>stdout__A.")
+	var buf bytes.Buffer
+	tracer := NewJSONTracer(&buf)
+	p.Trace = tracer
+	state := p.Start()
+	if err := state.Run(context.Background(), strings.NewReader(""), &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Err(); err != nil {
+		t.Fatal(err)
+	}
+	created := make([]string, 0)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e struct{ Type, Channel string }
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "new" {
+			created = append(created, e.Channel)
+		}
+	}
+	if got := strings.Join(created, " "); got != "#1 #2 #3 #4" {
+		t.Errorf("created channels %v, want #1 #2 #3 #4", got)
+	}
+}
@@ -1,8 +1,9 @@
-package main
+package pi
 
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 func copyStrIntMap(m map[string]int) map[string]int {
@@ -13,14 +14,6 @@ func copyStrIntMap(m map[string]int) map[string]int {
 	return n
 }
 
-func castStrSliceToInterface(src []string) []interface{} {
-	dst := make([]interface{}, len(src))
-	for i, str := range src {
-		dst[i] = str
-	}
-	return dst
-}
-
 func assert(condition bool) {
 	if !condition {
 		panic("failed assertion")
@@ -37,7 +30,16 @@ func (l Loc) String() string {
 	if len(l.Path) == 0 {
 		return "<internal>"
 	}
-	return fmt.Sprintf("%v:%v:%v", filepath.Base(l.Path), l.Ln, l.Col)
+	return fmt.Sprintf("%v:%v:%v", displayPath(l.Path), l.Ln, l.Col)
+}
+
+// Return the name of a file in messages (the base name, or the full name of
+// files in the library).
+func displayPath(path string) string {
+	if strings.HasPrefix(path, LibraryPrefix) {
+		return path
+	}
+	return filepath.Base(path)
 }
 
 // Set is a hash set using a map.
@@ -88,3 +90,13 @@ func (s Set) Copy() Set {
 	}
 	return newSet
 }
+
+// Span is a range in a source file. The end location is exclusive.
+type Span struct {
+	Start, End Loc
+}
+
+// Range returns the span itself so that nodes embedding a Span implement Node.
+func (s Span) Range() Span {
+	return s
+}
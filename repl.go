@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+const replHelp = `Enter PI processes or directives (#global, #attach) to run them.
+:load file.pi  Load a file and the files it attaches.
+:globals       List global channels and where they were declared.
+:files         List loaded files.
+:queue         List the nodes in the process queue.
+:ether         List the messages in the ether.
+:listeners     List the listeners on IO and global channels.
+:help          Show this help.
+:quit          Exit the REPL.`
+
+func replCmd(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	addIncludeFlag(flags)
+	stdinStr := flags.String("stdin", "",
+		"Standard input of the program (the REPL reads from the terminal).")
+	flags.Parse(args)
+
+	session := options.NewSession()
+	stdin := strings.NewReader(*stdinStr)
+	if err := session.Load(flags.Args()...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	session.Run(stdin, os.Stdout)
+	repl(session, os.Stdin, os.Stdout, stdin)
+	return 0
+}
+
+// Read lines from in and execute them in the session.
+func repl(session *pi.Session, in io.Reader, out io.Writer, stdin io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for ln := 1; ; ln++ {
+		fmt.Fprint(out, "pi> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		var err error
+		switch cmd, arg := splitCommand(line); cmd {
+		case "":
+			err = session.Exec(line, pi.Loc{Path: "repl", Ln: ln})
+		case ":load":
+			err = session.Load(strings.Fields(arg)...)
+		case ":globals":
+			for i, name := range session.Globals {
+				fmt.Fprintf(out, "%v (%v)\n", name, session.Origins[i])
+			}
+		case ":files":
+			for _, file := range session.Files {
+				fmt.Fprintln(out, file)
+			}
+		case ":queue":
+			for _, node := range session.Pi.Queue {
+				fmt.Fprintf(out, "%v %v\n", node.Proc.Location, session.CommandName(node.Proc))
+			}
+		case ":ether":
+			for _, m := range session.Pi.Ether {
+				fmt.Fprintf(out, "%v->%v\n",
+					session.ChannelName(m.Content), session.ChannelName(m.Channel))
+			}
+		case ":listeners":
+			for _, c := range session.Refs {
+				for _, node := range c.Listeners {
+					fmt.Fprintf(out, "%v %v %v\n", session.ChannelName(c),
+						node.Proc.Location, session.CommandName(node.Proc))
+				}
+			}
+		case ":help":
+			fmt.Fprintln(out, replHelp)
+		case ":quit":
+			return
+		default:
+			err = fmt.Errorf("unknown command %v", cmd)
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		session.Run(stdin, out)
+	}
+}
+
+// Split a REPL command (starting with a colon) from its argument.
+func splitCommand(line string) (string, string) {
+	if !strings.HasPrefix(line, ":") {
+		return "", ""
+	}
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
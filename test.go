@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bergwerf/pi-language/pi"
+)
+
+// A test case runs a program with the given standard input and compares the
+// standard output.
+type testCase struct {
+	Name   string
+	Path   string
+	Stdin  string
+	Stdout string
+}
+
+func testCmd(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	addIncludeFlag(flags)
+	maxCycles := flags.Uint64("max_cycles", 10000000,
+		"Fail a test that runs for more cycles.")
+	verbose := flags.Bool("v", false,
+		"Also list tests that pass.")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	cases, err := findTests(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := runTest(c, *maxCycles); err != nil {
+			fmt.Printf("--- FAIL: %v\n%v", c.Name, err)
+			failed++
+		} else if *verbose {
+			fmt.Printf("--- PASS: %v\n", c.Name)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("FAIL (%v of %v tests failed)\n", failed, len(cases))
+		return 1
+	}
+	fmt.Printf("ok (%v tests)\n", len(cases))
+	return 0
+}
+
+// Find test cases in the given files and directories. A file.pi is a test if
+// there is a file.out with the expected output (the input is read from file.in
+// if it exists) or if it has #test: "stdin" "stdout" directives with Go string
+// literals.
+func findTests(paths []string) ([]testCase, error) {
+	cases := make([]testCase, 0)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(path) != ".pi" {
+				return err
+			}
+			base := strings.TrimSuffix(path, ".pi")
+			if stdout, err := ioutil.ReadFile(base + ".out"); err == nil {
+				stdin, err := ioutil.ReadFile(base + ".in")
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				cases = append(cases, testCase{path, path, string(stdin), string(stdout)})
+			}
+			source, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			lines := strings.Split(string(source), "\n")
+			directives, _, _ := pi.ParseDirectives(string(source), path)
+			for _, d := range directives {
+				// Parse the whole line, as the strings may contain : and !.
+				line := strings.TrimSpace(lines[d.Start.Ln-1])
+				if !strings.HasPrefix(line, "#test:") {
+					continue
+				}
+				stdin, stdout, err := parseTestDirective(line[len("#test:"):])
+				if err != nil {
+					return fmt.Errorf("%v:%v; #test: %v", path, d.Start.Ln, err)
+				}
+				name := fmt.Sprintf("%v:%v", path, d.Start.Ln)
+				cases = append(cases, testCase{name, path, stdin, stdout})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cases, nil
+}
+
+// Parse the value of a #test directive, which may be followed by a comment.
+func parseTestDirective(value string) (string, string, error) {
+	strs := make([]string, 0, 2)
+	for len(strs) < 2 {
+		value = strings.TrimSpace(value)
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return "", "", fmt.Errorf("expected two quoted strings (stdin and stdout)")
+		}
+		str, _ := strconv.Unquote(quoted)
+		strs = append(strs, str)
+		value = value[len(quoted):]
+	}
+	if value = strings.TrimSpace(value); len(value) > 0 && !strings.HasPrefix(value, "!") {
+		return "", "", fmt.Errorf("unexpected %q after stdout", value)
+	}
+	return strs[0], strs[1], nil
+}
+
+// Run a test case. Returns a description of the failure.
+func runTest(c testCase, maxCycles uint64) error {
+	program, err := options.Compile(c.Path)
+	if err != nil {
+		var b strings.Builder
+		pi.WriteDiagnostics(&b, err, options.ReadFile)
+		return fmt.Errorf("%v", b.String())
+	}
+	got, ok := runCycles(program.Start(), c.Stdin, maxCycles)
+	if !ok {
+		return fmt.Errorf("    timeout after %v cycles\n%v", maxCycles, diff(c.Stdout, got))
+	} else if got != c.Stdout {
+		return fmt.Errorf("%v", diff(c.Stdout, got))
+	}
+	return nil
+}
+
+// Run a program state with the given standard input for at most maxCycles
+// cycles. Returns the standard output and false if the program did not end.
+func runCycles(state *pi.Pi, stdin string, maxCycles uint64) (string, bool) {
+	var stdout bytes.Buffer
+	err := state.RunLimits(context.Background(), strings.NewReader(stdin), &stdout,
+		pi.Limits{MaxCycles: maxCycles})
+	return stdout.String(), err == nil
+}
+
+// Return a line diff between the expected and the actual output. Lines are
+// prefixed with - (only expected), + (only actual) or a space.
+func diff(want string, got string) string {
+	a, b := splitLines(want), splitLines(got)
+	// Longest common subsequence of lines from the end.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var w strings.Builder
+	line := func(prefix string, s string) {
+		fmt.Fprintf(&w, "    %v %v\n", prefix, strings.TrimSuffix(s, "\n"))
+		if !strings.HasSuffix(s, "\n") {
+			fmt.Fprintf(&w, "    \\ No newline at end of output\n")
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line(" ", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			line("-", a[i])
+			i++
+		default:
+			line("+", b[j])
+			j++
+		}
+	}
+	return w.String()
+}
+
+// Split a string into lines that keep their line break.
+func splitLines(s string) []string {
+	lines := make([]string, 0)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		lines = append(lines, s[:i])
+		s = s[i:]
+	}
+	return lines
+}
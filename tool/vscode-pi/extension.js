@@ -0,0 +1,19 @@
+// Starts the PI language server (pi lsp) for .pi files.
+const vscode = require('vscode');
+const { LanguageClient } = require('vscode-languageclient/node');
+
+let client;
+
+function activate(context) {
+  const command = vscode.workspace.getConfiguration('pi').get('path') || 'pi';
+  client = new LanguageClient('pi', 'PI Language Server',
+    { command, args: ['lsp'] },
+    { documentSelector: [{ scheme: 'file', language: 'pi' }] });
+  context.subscriptions.push(client.start());
+}
+
+function deactivate() {
+  return client ? client.stop() : undefined;
+}
+
+module.exports = { activate, deactivate };